@@ -2,17 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/landakram/plaid-cli/pkg/plaid_cli"
 	"github.com/manifoldco/promptui"
@@ -53,37 +62,9 @@ func IsValidLanguageCode(lang string) bool {
 	return supportedLanguages[lang]
 }
 
-func main() {
-	log.SetFlags(0)
-
-	usr, _ := user.Current()
-	dir := usr.HomeDir
-	viper.SetDefault("cli.data_dir", filepath.Join(dir, ".plaid-cli"))
-
-	dataDir := viper.GetString("cli.data_dir")
-	data, err := plaid_cli.LoadData(dataDir)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	viper.SetConfigName("config")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath(dataDir)
-	viper.AddConfigPath(".")
-	err = viper.ReadInConfig()
-	if err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// Config file not found; ignore error if desired
-		} else {
-			log.Fatal(err)
-		}
-	}
-
-	viper.SetEnvPrefix("")
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
-	viper.AutomaticEnv()
-
+// detectLocale guesses the user's country and language from the system's
+// locale.
+func detectLocale() (string, string) {
 	tag, err := locale.Detect()
 	if err != nil {
 		tag = language.AmericanEnglish
@@ -99,61 +80,260 @@ func main() {
 		country = "US"
 	}
 
-	lang := base.String()
+	return country, base.String()
+}
 
-	viper.SetDefault("plaid.countries", []string{country})
-	countriesOpt := viper.GetStringSlice("plaid.countries")
-	var countries []string
-	for _, c := range countriesOpt {
-		countries = append(countries, strings.ToUpper(c))
-	}
+func configureEnv() {
+	viper.SetEnvPrefix("")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	viper.AutomaticEnv()
+}
+
+// Commands declare how much initialization they need with an annotation.
+// Commands without it only get environment variables.
+const requiresAnnotation = "requires"
 
-	viper.SetDefault("plaid.language", lang)
-	lang = viper.GetString("plaid.language")
+const (
+	// requiresConfig commands need the config file and data directory.
+	requiresConfig = "config"
+	// requiresAPI commands also need a Plaid client.
+	requiresAPI = "api"
+)
+
+// newProfileAnnotation marks commands that may create the selected profile.
+const newProfileAnnotation = "new-profile"
+
+func requires(requirement string) map[string]string {
+	return map[string]string{requiresAnnotation: requirement}
+}
 
+// validateLocale checks the countries and language passed to Plaid. Only
+// commands that use them call it, so a bad locale doesn't break the rest.
+func validateLocale(countries []string, lang string) error {
 	if !AreValidCountries(countries) {
-		log.Fatalln("⚠️  Invalid countries. Please configure `plaid.countries` (using an envvar, PLAID_COUNTRIES, or in plaid-cli's config file) to a subset of countries that Plaid supports. Plaid supports the following countries: ", plaidSupportedCountries)
+		return plaid_cli.NewConfigError("⚠️  Invalid countries. Please configure `plaid.countries` (using an envvar, PLAID_COUNTRIES, or in plaid-cli's config file) to a subset of countries that Plaid supports. Plaid supports the following countries: %v", plaidSupportedCountries)
 	}
 
 	if !IsValidLanguageCode(lang) {
-		log.Fatalln("⚠️  Invalid language code. Please configure `plaid.language` (using an envvar, PLAID_LANGUAGE, or in plaid-cli's config file) to a language that Plaid supports. Plaid supports the following languages: ", plaidSupportedLanguages)
+		return plaid_cli.NewConfigError("⚠️  Invalid language code. Please configure `plaid.language` (using an envvar, PLAID_LANGUAGE, or in plaid-cli's config file) to a language that Plaid supports. Plaid supports the following languages: %v", plaidSupportedLanguages)
 	}
 
-	viper.SetDefault("plaid.environment", "development")
-	plaidEnvStr := strings.ToLower(viper.GetString("plaid.environment"))
+	return nil
+}
 
-	var plaidEnv plaid.Environment
-	switch plaidEnvStr {
-	case "development":
-		plaidEnv = plaid.Development
-	case "production":
-		plaidEnv = plaid.Production
-	default:
-		log.Fatalln("Invalid plaid environment. Valid plaid environments are 'development' or 'production'.")
-	}
+func main() {
+	log.SetFlags(0)
+
+	usr, _ := user.Current()
+	dir := usr.HomeDir
+	viper.SetDefault("cli.data_dir", filepath.Join(dir, ".plaid-cli"))
+
+	var data *plaid_cli.Data
+	var client *plaid.Client
+	var clientOpts plaid.ClientOptions
+	var linker *plaid_cli.Linker
+	var credentials plaid_cli.Credentials
+	var countries []string
+	var lang string
+	var itemEnv plaid_cli.ItemEnvironment
+
+	var configPath string
+	var localeDefaults map[string]bool
+
+	// loadConfig loads configuration and data for the selected profile. It
+	// runs after flags are parsed so that --profile can be honored. Unless
+	// newProfile is set, the profile must already exist.
+	loadConfig := func(newProfile bool) error {
+		dataDir := viper.GetString("cli.data_dir")
+		configPath = filepath.Join(dataDir, "config.toml")
+
+		viper.SetConfigName("config")
+		viper.SetConfigType("toml")
+		viper.AddConfigPath(dataDir)
+		viper.AddConfigPath(".")
+		err := viper.ReadInConfig()
+		if err != nil {
+			if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+				// Config file not found; ignore error if desired
+			} else {
+				return plaid_cli.NewConfigError("Error reading config file: %s", err)
+			}
+		}
+		if used := viper.ConfigFileUsed(); used != "" {
+			configPath = used
+		}
+
+		profile := viper.GetString("cli.profile")
+		if !plaid_cli.IsValidProfileName(profile) {
+			return plaid_cli.NewConfigError("Invalid profile name `%s`. Valid characters: [0-9A-Za-z_-]", profile)
+		}
+
+		// Settings in a [profiles.NAME] section override the top-level ones.
+		if profile != plaid_cli.DefaultProfile {
+			sub := viper.Sub("profiles." + profile)
+			if sub == nil && !newProfile && !plaid_cli.ProfileExists(dataDir, profile) {
+				var configured []string
+				for name := range viper.GetStringMap("profiles") {
+					configured = append(configured, name)
+				}
+				return plaid_cli.NewConfigError("Unknown profile `%s`. Known profiles: %s. Create it with `plaid-cli --profile %s init` or `plaid-cli --profile %s config set`.", profile, strings.Join(plaid_cli.KnownProfiles(dataDir, configured), ", "), profile, profile)
+			}
+			if sub != nil {
+				err = viper.MergeConfigMap(sub.AllSettings())
+				if err != nil {
+					return plaid_cli.NewConfigError("Error reading profile %s: %s", profile, err)
+				}
+			}
+		}
+
+		data, err = plaid_cli.LoadData(plaid_cli.ProfileDir(dataDir, profile))
+		if err != nil {
+			return err
+		}
+
+		country, detectedLang := detectLocale()
+		viper.SetDefault("plaid.countries", []string{country})
+		viper.SetDefault("plaid.language", detectedLang)
+		localeDefaults = map[string]bool{
+			"plaid.countries": true,
+			"plaid.language":  true,
+		}
 
-	opts := plaid.ClientOptions{
-		viper.GetString("plaid.client_id"),
-		viper.GetString("plaid.secret"),
-		plaidEnv,
-		&http.Client{},
+		viper.SetDefault("plaid.environment", "development")
+
+		defaultPolicy := plaid_cli.DefaultRetryPolicy()
+		viper.SetDefault("plaid.retry.max_attempts", defaultPolicy.MaxAttempts)
+		viper.SetDefault("plaid.retry.base_delay", defaultPolicy.BaseDelay)
+		viper.SetDefault("plaid.retry.max_delay", defaultPolicy.MaxDelay)
+		viper.SetDefault("plaid.retry.product_not_ready_interval", defaultPolicy.ProductNotReadyInterval)
+		viper.SetDefault("plaid.retry.product_not_ready_timeout", defaultPolicy.ProductNotReadyTimeout)
+		viper.SetDefault("plaid.retry.item_wait", time.Minute)
+		viper.SetDefault("plaid.command_timeout", 30*time.Second)
+		viper.SetDefault("cli.hook_timeout", time.Minute)
+		viper.SetDefault("budget.alert_percent", 100)
+		viper.SetDefault("transfers.window_days", plaid_cli.DefaultTransferWindow)
+		viper.SetDefault("anomalies.large_factor", 3.0)
+		viper.SetDefault("anomalies.new_merchant_amount", 100.0)
+		viper.SetDefault("anomalies.duplicate_days", 1)
+
+		countriesOpt := viper.GetStringSlice("plaid.countries")
+		for _, c := range countriesOpt {
+			countries = append(countries, strings.ToUpper(c))
+		}
+
+		lang = viper.GetString("plaid.language")
+
+		return nil
 	}
 
-	client, err := plaid.NewClient(opts)
+	// setupClient builds the Plaid client. Countries and language aren't
+	// validated here since only some commands use them; see validateLocale.
+	setupClient := func() error {
+		plaidEnvStr := strings.ToLower(viper.GetString("plaid.environment"))
+
+		var plaidEnv plaid.Environment
+		switch plaidEnvStr {
+		case "development":
+			plaidEnv = plaid.Development
+		case "production":
+			plaidEnv = plaid.Production
+		default:
+			return plaid_cli.NewConfigError("Invalid plaid environment. Valid plaid environments are 'development' or 'production'.")
+		}
+
+		retryPolicy := plaid_cli.RetryPolicy{
+			MaxAttempts:             viper.GetInt("plaid.retry.max_attempts"),
+			BaseDelay:               viper.GetDuration("plaid.retry.base_delay"),
+			MaxDelay:                viper.GetDuration("plaid.retry.max_delay"),
+			ProductNotReadyInterval: viper.GetDuration("plaid.retry.product_not_ready_interval"),
+			ProductNotReadyTimeout:  viper.GetDuration("plaid.retry.product_not_ready_timeout"),
+		}
 
-	if err != nil {
-		log.Fatal(err)
+		// Credentials set directly take precedence over commands.
+		for _, key := range []string{"plaid.client_id", "plaid.secret"} {
+			commandKey := key + "_command"
+			if viper.IsSet(key) || !viper.IsSet(commandKey) {
+				continue
+			}
+
+			value, err := plaid_cli.RunSecretCommand(commandKey, viper.GetString(commandKey), viper.GetDuration("plaid.command_timeout"))
+			if err != nil {
+				return err
+			}
+			viper.Set(key, value)
+		}
+
+		if !viper.IsSet("plaid.client_id") {
+			return plaid_cli.NewConfigError("⚠️  PLAID_CLIENT_ID not set. Run `plaid-cli init` or see `plaid-cli --help` for configuration instructions.")
+		}
+		if !viper.IsSet("plaid.secret") {
+			return plaid_cli.NewConfigError("⚠️  PLAID_SECRET not set. Run `plaid-cli init` or see `plaid-cli --help` for configuration instructions.")
+		}
+
+		clientOpts = plaid.ClientOptions{
+			ClientID:    viper.GetString("plaid.client_id"),
+			Secret:      viper.GetString("plaid.secret"),
+			Environment: plaidEnv,
+			HTTPClient:  plaid_cli.NewRetryClient(retryPolicy),
+		}
+		var err error
+		client, err = plaid.NewClient(clientOpts)
+		if err != nil {
+			return err
+		}
+
+		itemEnv = plaid_cli.ItemEnvironment{
+			Environment: plaidEnvStr,
+			ClientID:    clientOpts.ClientID,
+		}
+
+		credentials = plaid_cli.Credentials{
+			ClientID: clientOpts.ClientID,
+			Secret:   clientOpts.Secret,
+		}
+		linker = plaid_cli.NewLinker(data, client, credentials, countries, lang)
+
+		return nil
 	}
 
-	linker := plaid_cli.NewLinker(data, client, countries, lang)
+	// setup does only as much initialization as the command requires, so that
+	// commands that don't talk to Plaid work without credentials.
+	setup := func(cmd *cobra.Command, args []string) error {
+		// Errors past this point are runtime errors, not usage errors.
+		cmd.SilenceUsage = true
+
+		configureEnv()
+
+		requirement := cmd.Annotations[requiresAnnotation]
+		if requirement == "" {
+			return nil
+		}
+
+		if err := loadConfig(cmd.Annotations[newProfileAnnotation] != ""); err != nil {
+			return err
+		}
+
+		if requirement == requiresAPI {
+			return setupClient()
+		}
+
+		return nil
+	}
 
+	var accountSelectionFlag bool
 	linkCommand := &cobra.Command{
-		Use:   "link [ITEM-ID-OR-ALIAS]",
-		Short: "Link an institution so plaid-cli can pull transactions",
-		Long:  "Link an institution so plaid-cli can pull transactions. An item ID or alias can be passed to initiate a relink.",
-		Args:  cobra.MaximumNArgs(1),
+		Use:         "link [ITEM-ID-OR-ALIAS]",
+		Short:       "Link an institution so plaid-cli can pull transactions",
+		Long:        "Link an institution so plaid-cli can pull transactions. An item ID or alias can be passed to initiate a relink.",
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: requires(requiresAPI),
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := validateLocale(countries, lang); err != nil {
+				fatal(err)
+			}
+
 			port := viper.GetString("link.port")
+			linker.Webhook = viper.GetString("link.webhook")
 
 			var tokenPair *plaid_cli.TokenPair
 
@@ -167,20 +347,37 @@ func main() {
 					itemOrAlias = itemID
 				}
 
-				err = linker.Relink(itemOrAlias, port)
+				if err := data.CheckItemEnvironment(itemOrAlias, itemEnv); err != nil {
+					fatal(err)
+				}
+
+				if accountSelectionFlag {
+					err = linker.RelinkWithAccountSelection(itemOrAlias, port)
+				} else {
+					err = linker.Relink(itemOrAlias, port)
+				}
+				if err != nil {
+					fatal(err)
+				}
 				log.Println("Institution relinked!")
 				return
 			} else {
 				tokenPair, err = linker.Link(port)
 				if err != nil {
-					log.Fatalln(err)
+					fatal(err)
 				}
+				linkedAt := time.Now()
 				data.Tokens[tokenPair.ItemID] = tokenPair.AccessToken
+				data.Items[tokenPair.ItemID] = plaid_cli.ItemEnvironment{
+					Environment: itemEnv.Environment,
+					ClientID:    itemEnv.ClientID,
+					LinkedAt:    &linkedAt,
+				}
 				err = data.Save()
 			}
 
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 
 			log.Println("Institution linked!")
@@ -212,13 +409,13 @@ func main() {
 
 			input, err := prompt.Run()
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 
 			if input != "" {
 				err = SetAlias(data, tokenPair.ItemID, input)
 				if err != nil {
-					log.Fatalln(err)
+					fatal(err)
 				}
 			}
 		},
@@ -226,10 +423,14 @@ func main() {
 
 	linkCommand.Flags().StringP("port", "p", "8080", "Port on which to serve Plaid Link")
 	viper.BindPFlag("link.port", linkCommand.Flags().Lookup("port"))
+	linkCommand.Flags().String("webhook", "", "URL Plaid sends webhooks about the institution to, e.g. for webhook serve")
+	viper.BindPFlag("link.webhook", linkCommand.Flags().Lookup("webhook"))
+	linkCommand.Flags().BoolVar(&accountSelectionFlag, "account-selection", false, "When relinking, let you change which accounts are shared with plaid-cli")
 
 	tokensCommand := &cobra.Command{
-		Use:   "tokens",
-		Short: "List access tokens",
+		Use:         "tokens",
+		Short:       "List access tokens",
+		Annotations: requires(requiresConfig),
 		Run: func(cmd *cobra.Command, args []string) {
 			resolved := make(map[string]string)
 			for itemID, token := range data.Tokens {
@@ -242,45 +443,48 @@ func main() {
 
 			printJSON, err := json.MarshalIndent(resolved, "", "  ")
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 			fmt.Println(string(printJSON))
 		},
 	}
 
 	aliasCommand := &cobra.Command{
-		Use:   "alias [ITEM-ID] [NAME]",
-		Short: "Give a linked institution a friendly name",
-		Long:  "Give a linked institution a friendly name. You can use this name instead of the idem ID in most commands.",
-		Args:  cobra.ExactArgs(2),
+		Use:         "alias [ITEM-ID] [NAME]",
+		Short:       "Give a linked institution a friendly name",
+		Long:        "Give a linked institution a friendly name. You can use this name instead of the idem ID in most commands.",
+		Args:        cobra.ExactArgs(2),
+		Annotations: requires(requiresConfig),
 		Run: func(cmd *cobra.Command, args []string) {
 			itemID := args[0]
 			alias := args[1]
 
 			err := SetAlias(data, itemID, alias)
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 		},
 	}
 
 	aliasesCommand := &cobra.Command{
-		Use:   "aliases",
-		Short: "List aliases",
+		Use:         "aliases",
+		Short:       "List aliases",
+		Annotations: requires(requiresConfig),
 		Run: func(cmd *cobra.Command, args []string) {
 			printJSON, err := json.MarshalIndent(data.Aliases, "", "  ")
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 			fmt.Println(string(printJSON))
 		},
 	}
 
 	accountsCommand := &cobra.Command{
-		Use:   "accounts [ITEM-ID-OR-ALIAS]",
-		Short: "List accounts for a given institution",
-		Long:  "List accounts for a given institution. An account ID returned from this command can be used as a filter when listing transactions.",
-		Args:  cobra.ExactArgs(1),
+		Use:         "accounts [ITEM-ID-OR-ALIAS]",
+		Short:       "List accounts for a given institution",
+		Long:        "List accounts for a given institution. An account ID returned from this command can be used as a filter when listing transactions.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresAPI),
 		Run: func(cmd *cobra.Command, args []string) {
 			itemOrAlias := args[0]
 			itemID, ok := data.Aliases[itemOrAlias]
@@ -288,7 +492,7 @@ func main() {
 				itemOrAlias = itemID
 			}
 
-			err := WithRelinkOnAuthError(itemOrAlias, data, linker, func() error {
+			err := WithItemErrorHandling(itemOrAlias, data, linker, itemEnv, func() error {
 				token := data.Tokens[itemOrAlias]
 				res, err := client.GetAccounts(token)
 				if err != nil {
@@ -306,19 +510,258 @@ func main() {
 			})
 
 			if err != nil {
-				log.Fatalln(err)
+				fatal(err)
 			}
 		},
 	}
 
+	// transactionPages calls fn with every page of an item's transactions in
+	// a date range, until ctx is canceled. It doesn't relink the item, see
+	// fetchTransactions.
+	transactionPages := func(ctx context.Context, d *plaid_cli.Data, itemID string, dateRange plaid_cli.DateRange, accountID string, fn func([]plaid_cli.Transaction) error) error {
+		token := d.Tokens[itemID]
+
+		var accountIDs []string
+		if len(accountID) > 0 {
+			accountIDs = append(accountIDs, accountID)
+		}
+
+		options := plaid.GetTransactionsOptions{
+			StartDate:  dateRange.From.Format(plaid_cli.DateFormat),
+			EndDate:    dateRange.To.Format(plaid_cli.DateFormat),
+			AccountIDs: accountIDs,
+		}
+
+		return newTransactionFetcher(clientOpts).Each(ctx, token, options, func(page []plaid.Transaction) error {
+			return fn(plaid_cli.NewTransactions(page))
+		})
+	}
+
+	// fetchTransactions fetches every transaction of an item in a date
+	// range, relinking the item if needed.
+	fetchTransactions := func(itemID string, dateRange plaid_cli.DateRange, accountID string) ([]plaid_cli.Transaction, error) {
+		var transactions []plaid_cli.Transaction
+
+		err := WithItemErrorHandling(itemID, data, linker, itemEnv, func() error {
+			transactions = []plaid_cli.Transaction{}
+
+			ctx, stop := interruptContext()
+			defer stop()
+
+			return transactionPages(ctx, data, itemID, dateRange, accountID, func(page []plaid_cli.Transaction) error {
+				transactions = append(transactions, page...)
+				return nil
+			})
+		})
+
+		return transactions, err
+	}
+
+	// fetchCounterparts fetches the transactions of every other item in d
+	// around a date range with fetch, to pair transfers with. It returns nil
+	// unless --detect-transfers is set.
+	fetchCounterparts := func(cmd *cobra.Command, d *plaid_cli.Data, itemID string, dateRange plaid_cli.DateRange, rules plaid_cli.Rules, fetch func(string, plaid_cli.DateRange, string) ([]plaid_cli.Transaction, error)) ([]plaid_cli.Transaction, error) {
+		if detect, _ := cmd.Flags().GetBool("detect-transfers"); !detect {
+			return nil, nil
+		}
+
+		window := viper.GetInt("transfers.window_days")
+		dateRange.From = dateRange.From.AddDate(0, 0, -window)
+		dateRange.To = dateRange.To.AddDate(0, 0, window)
+		if now := time.Now(); dateRange.To.After(now) {
+			dateRange.To = now
+		}
+
+		counterparts := []plaid_cli.Transaction{}
+		for _, otherID := range resolveItems(d, nil) {
+			if otherID == itemID {
+				continue
+			}
+
+			transactions, err := fetch(otherID, dateRange, "")
+			if err != nil {
+				return nil, err
+			}
+			counterparts = append(counterparts, d.Annotate(rules.Apply(transactions))...)
+		}
+
+		return counterparts, nil
+	}
+
+	// syncChanges syncs an item's transactions in d from its stored cursor
+	// with c. It returns the changes as events, after applying rules and
+	// annotations, and the cursor to save once they were handled. The first
+	// sync of an item returns no events, only where it's at, unless
+	// emitExisting is set.
+	syncChanges := func(c *plaid.Client, d *plaid_cli.Data, itemID string, rules plaid_cli.Rules, emitExisting bool) ([]plaid_cli.SyncEvent, string, error) {
+		// Another command may have synced the item since d was loaded.
+		dataMu.Lock()
+		err := d.ReloadSyncCursors()
+		dataMu.Unlock()
+		if err != nil {
+			return nil, "", err
+		}
+
+		var changes *plaid_cli.SyncChanges
+		err = WithItemErrorHandling(itemID, d, linker, itemEnv, func() error {
+			var err error
+			changes, err = plaid_cli.SyncTransactions(c, credentials, d.Tokens[itemID], d.SyncCursors[itemID])
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		events := []plaid_cli.SyncEvent{}
+		if _, synced := d.SyncCursors[itemID]; synced || emitExisting {
+			changes.Added = d.Annotate(rules.Apply(changes.Added))
+			changes.Modified = d.Annotate(rules.Apply(changes.Modified))
+			events = append(events, changes.Events(itemID)...)
+		}
+
+		return events, changes.Cursor, nil
+	}
+
+	// syncItem syncs an item's transactions and calls emit with each change.
+	// The cursor is only saved once every event was emitted, so a change is
+	// emitted again rather than lost if plaid-cli stops halfway.
+	syncItem := func(itemID string, rules plaid_cli.Rules, emitExisting bool, emit func(plaid_cli.SyncEvent) error) error {
+		events, cursor, err := syncChanges(client, data, itemID, rules, emitExisting)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+
+		dataMu.Lock()
+		defer dataMu.Unlock()
+		return data.SaveSyncCursor(itemID, cursor)
+	}
+
 	var fromFlag string
 	var toFlag string
+	var sinceLastRunFlag bool
 	var accountID string
 	var outputFormat string
+	var sortFlag string
+	var groupByFlag string
+	var outputFile string
+
+	// writeTransactions writes transactions to out in the format given by the
+	// transactions flags, after applying rules and then the filter. Unless
+	// they have to be sorted, grouped or paired with counterparts to find
+	// transfers, they are written page by page as they're fetched.
+	writeTransactions := func(out io.Writer, itemID string, dateRange plaid_cli.DateRange, rules plaid_cli.Rules, filter *plaid_cli.TransactionFilter, counterparts []plaid_cli.Transaction, converter *plaid_cli.Converter) error {
+		if groupByFlag != "" {
+			transactions, err := fetchTransactions(itemID, dateRange, accountID)
+			if err != nil {
+				return err
+			}
+
+			transactions, err = prepareTransactions(transactions, counterparts, converter, rules, data, filter)
+			if err != nil {
+				return err
+			}
+
+			summary, err := plaid_cli.Summarize(transactions, groupByFlag)
+			if err != nil {
+				return err
+			}
+			if sortFlag != "" {
+				if err := summary.Sort(sortFlag); err != nil {
+					return err
+				}
+			}
+
+			return writeSummary(out, summary, outputFormat)
+		}
+
+		serializer, err := NewTransactionSerializer(outputFormat, out)
+		if err != nil {
+			return err
+		}
+
+		if sortFlag != "" || counterparts != nil {
+			transactions, err := fetchTransactions(itemID, dateRange, accountID)
+			if err != nil {
+				return err
+			}
+
+			transactions, err = prepareTransactions(transactions, counterparts, converter, rules, data, filter)
+			if err != nil {
+				return err
+			}
+			if sortFlag != "" {
+				if err := plaid_cli.SortTransactions(transactions, sortFlag); err != nil {
+					return err
+				}
+			}
+
+			if err := serializer.write(transactions); err != nil {
+				return err
+			}
+		} else {
+			// Only failures before anything was written are remediated and
+			// retried: running again would write the same pages twice.
+			wrote := false
+			var streamErr error
+			err = WithItemErrorHandling(itemID, data, linker, itemEnv, func() error {
+				ctx, stop := interruptContext()
+				defer stop()
+
+				err := transactionPages(ctx, data, itemID, dateRange, accountID, func(page []plaid_cli.Transaction) error {
+					page, err := prepareTransactions(page, nil, converter, rules, data, filter)
+					if err != nil {
+						return err
+					}
+					wrote = true
+					return serializer.write(page)
+				})
+				if err != nil && wrote {
+					streamErr = err
+					return nil
+				}
+				return err
+			})
+			if streamErr != nil {
+				if itemErr := plaid_cli.ClassifyError(itemID, streamErr); itemErr != nil {
+					return itemErr
+				}
+				return streamErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return serializer.close()
+	}
+
 	transactionsCommand := &cobra.Command{
 		Use:   "transactions [ITEM-ID-OR-ALIAS]",
 		Short: "List transactions for a given institution",
-		Args:  cobra.ExactArgs(1),
+		Long: `List transactions for a given institution.
+
+Dates can be given as YYYY-MM-DD, relative to today (-7d, 30d, 2w, 3m, 1y) or as
+today or yesterday. --from also accepts a named range, which sets --to as well
+unless it's given: this-month, last-month, ytd or last-year.
+
+Filters are applied before the transactions are written. Amounts are as
+reported by Plaid, where money leaving the account is positive. Categories
+match Plaid's hierarchy by prefix, so "Food and Drink" includes
+"Food and Drink > Restaurants".
+
+Transfers are transactions Plaid categorizes as transfers or credit card
+payments. With --detect-transfers, transactions of your other linked
+institutions are fetched too, and an outflow matching an inflow of the same
+amount in another account within transfers.window_days (3 by default) is
+marked as a transfer as well.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresAPI),
 		Run: func(cmd *cobra.Command, args []string) {
 			itemOrAlias := args[0]
 			itemID, ok := data.Aliases[itemOrAlias]
@@ -326,63 +769,115 @@ func main() {
 				itemOrAlias = itemID
 			}
 
-			err := WithRelinkOnAuthError(itemOrAlias, data, linker, func() error {
-				token := data.Tokens[itemOrAlias]
-
-				var accountIDs []string
-				if len(accountID) > 0 {
-					accountIDs = append(accountIDs, accountID)
+			now := time.Now()
+			from := fromFlag
+			if sinceLastRunFlag {
+				if lastRun, ok := data.LastRuns[itemOrAlias]; ok {
+					// The previous run already covered its last day.
+					last, err := plaid_cli.ParseDate(lastRun, now)
+					if err != nil {
+						fatal(err)
+					}
+					next := last.AddDate(0, 0, 1)
+					if today, _ := plaid_cli.ParseDate("today", now); toFlag == "" && next.After(today) {
+						log.Printf("Nothing new since the last run, which ended %s.", lastRun)
+						return
+					}
+					from = next.Format(plaid_cli.DateFormat)
 				}
+			}
 
-				options := plaid.GetTransactionsOptions{
-					StartDate:  fromFlag,
-					EndDate:    toFlag,
-					AccountIDs: accountIDs,
-					Count:      100,
-					Offset:     0,
-				}
+			dateRange, err := plaid_cli.ResolveDateRange(from, toFlag, now)
+			if err != nil {
+				fatal(err)
+			}
 
-				transactions, err := AllTransactions(options, client, token)
-				if err != nil {
-					return err
-				}
+			filter, err := transactionFilterFromFlags(cmd)
+			if err != nil {
+				fatal(err)
+			}
 
-				serializer, err := NewTransactionSerializer(outputFormat)
-				if err != nil {
-					return err
+			if groupByFlag != "" {
+				err = plaid_cli.ValidateGroupBy(groupByFlag)
+				if err == nil && sortFlag != "" {
+					err = plaid_cli.ValidateSort(sortFlag, plaid_cli.SummarySortFields)
 				}
+			} else if sortFlag != "" {
+				err = plaid_cli.ValidateSort(sortFlag, plaid_cli.TransactionSortFields)
+			}
+			if err != nil {
+				fatal(err)
+			}
 
-				b, err := serializer.serialize(transactions)
-				if err != nil {
-					return err
-				}
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
 
-				fmt.Println(string(b))
+			converter, err := converterFromFlags(cmd, data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
 
-				return nil
-			})
+			counterparts, err := fetchCounterparts(cmd, data, itemOrAlias, dateRange, rules, fetchTransactions)
+			if err != nil {
+				fatal(err)
+			}
+
+			out, err := plaid_cli.OpenOutput(outputFile)
+			if err != nil {
+				fatal(err)
+			}
 
+			err = writeTransactions(out, itemOrAlias, dateRange, rules, filter, counterparts, converter)
+			if err == nil {
+				err = out.Commit()
+			}
 			if err != nil {
-				log.Fatalln(err)
+				out.Abort()
+				fatal(err)
+			}
+
+			if sinceLastRunFlag {
+				data.LastRuns[itemOrAlias] = dateRange.To.Format(plaid_cli.DateFormat)
+				if err := data.SaveLastRuns(); err != nil {
+					fatal(err)
+				}
 			}
 		},
 	}
-	transactionsCommand.Flags().StringVarP(&fromFlag, "from", "f", "", "Date of first transaction (required)")
-	transactionsCommand.MarkFlagRequired("from")
+	transactionsCommand.Flags().StringVarP(&fromFlag, "from", "f", "30d", "Date of first transaction, or a named range")
+	transactionsCommand.Flags().StringVarP(&toFlag, "to", "t", "", "Date of last transaction (default today, or the end of the --from range)")
+	transactionsCommand.Flags().BoolVar(&sinceLastRunFlag, "since-last-run", false, "Start the day after the last date fetched by a previous --since-last-run for this institution")
 
-	transactionsCommand.Flags().StringVarP(&toFlag, "to", "t", "", "Date of last transaction (required)")
-	transactionsCommand.MarkFlagRequired("to")
-
-	transactionsCommand.Flags().StringVarP(&outputFormat, "output-format", "o", "json", "Output format")
+	transactionsCommand.Flags().StringVarP(&outputFormat, "output-format", "o", "json", "Output format (json, ndjson or csv, or with --group-by table, csv or json)")
 	transactionsCommand.Flags().StringVarP(&accountID, "account-id", "a", "", "Fetch transactions for this account ID only.")
-
-	var withStatusFlag bool
-	var withOptionalMetadataFlag bool
-	insitutionCommand := &cobra.Command{
-		Use:   "institution [ITEM-ID-OR-ALIAS]",
-		Short: "Get information about an institution",
-		Long:  "Get information about an institution. Status can be reported using a flag.",
-		Args:  cobra.ExactArgs(1),
+	transactionsCommand.Flags().StringVar(&outputFile, "output", "", "Write to this file instead of stdout. The file is only replaced once the export succeeds")
+	transactionsCommand.Flags().StringVar(&sortFlag, "sort", "", "Sort by date, amount, name, merchant, category or account, or with --group-by by key, count, total, average, inflow or outflow. Prefix with - for descending order")
+	transactionsCommand.Flags().StringVar(&groupByFlag, "group-by", "", "Print totals per category, merchant, account, day, week or month instead of transactions")
+	addTransactionFilterFlags(transactionsCommand)
+	addConvertFlag(transactionsCommand)
+
+	var summaryFromFlag string
+	var summaryToFlag string
+	var summaryAccountID string
+	var summaryFormat string
+	var summarySortFlag string
+	var summaryGroupByFlag string
+	var summaryOutputFile string
+	summaryCommand := &cobra.Command{
+		Use:   "summary [ITEM-ID-OR-ALIAS]",
+		Short: "Summarize transactions for a given institution",
+		Long: `Summarize transactions for a given institution.
+
+Transactions are grouped by --group-by and each group gets a count, a total, an
+average and separate inflow and outflow totals. Totals and averages are as
+reported by Plaid, where money leaving the account is positive; inflow and
+outflow are both positive.
+
+Dates and filters work like they do for transactions.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresAPI),
 		Run: func(cmd *cobra.Command, args []string) {
 			itemOrAlias := args[0]
 			itemID, ok := data.Aliases[itemOrAlias]
@@ -390,189 +885,2601 @@ func main() {
 				itemOrAlias = itemID
 			}
 
-			err := WithRelinkOnAuthError(itemOrAlias, data, linker, func() error {
-				token := data.Tokens[itemOrAlias]
+			dateRange, err := plaid_cli.ResolveDateRange(summaryFromFlag, summaryToFlag, time.Now())
+			if err != nil {
+				fatal(err)
+			}
 
-				itemResp, err := client.GetItem(token)
-				if err != nil {
-					return err
-				}
+			filter, err := transactionFilterFromFlags(cmd)
+			if err != nil {
+				fatal(err)
+			}
 
-				instID := itemResp.Item.InstitutionID
+			err = plaid_cli.ValidateGroupBy(summaryGroupByFlag)
+			if err == nil && summarySortFlag != "" {
+				err = plaid_cli.ValidateSort(summarySortFlag, plaid_cli.SummarySortFields)
+			}
+			if err != nil {
+				fatal(err)
+			}
 
-				opts := plaid.GetInstitutionByIDOptions{
-					IncludeOptionalMetadata: withOptionalMetadataFlag,
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			transactions, err := fetchTransactions(itemOrAlias, dateRange, summaryAccountID)
+			if err != nil {
+				fatal(err)
+			}
+
+			counterparts, err := fetchCounterparts(cmd, data, itemOrAlias, dateRange, rules, fetchTransactions)
+			if err != nil {
+				fatal(err)
+			}
+
+			converter, err := converterFromFlags(cmd, data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			transactions, err = prepareTransactions(transactions, counterparts, converter, rules, data, filter)
+			if err != nil {
+				fatal(err)
+			}
+
+			summary, err := plaid_cli.Summarize(transactions, summaryGroupByFlag)
+			if err != nil {
+				fatal(err)
+			}
+
+			if summarySortFlag != "" {
+				if err := summary.Sort(summarySortFlag); err != nil {
+					fatal(err)
+				}
+			}
+
+			out, err := plaid_cli.OpenOutput(summaryOutputFile)
+			if err != nil {
+				fatal(err)
+			}
+
+			err = writeSummary(out, summary, summaryFormat)
+			if err == nil {
+				err = out.Commit()
+			}
+			if err != nil {
+				out.Abort()
+				fatal(err)
+			}
+		},
+	}
+	summaryCommand.Flags().StringVarP(&summaryFromFlag, "from", "f", "30d", "Date of first transaction, or a named range")
+	summaryCommand.Flags().StringVarP(&summaryToFlag, "to", "t", "", "Date of last transaction (default today, or the end of the --from range)")
+	summaryCommand.Flags().StringVarP(&summaryAccountID, "account-id", "a", "", "Summarize transactions for this account ID only.")
+	summaryCommand.Flags().StringVarP(&summaryFormat, "output-format", "o", "table", "Output format (table, csv or json)")
+	summaryCommand.Flags().StringVar(&summaryOutputFile, "output", "", "Write to this file instead of stdout. The file is only replaced once the summary succeeds")
+	summaryCommand.Flags().StringVar(&summarySortFlag, "sort", "", "Sort groups by key, count, total, average, inflow or outflow. Prefix with - for descending order")
+	summaryCommand.Flags().StringVar(&summaryGroupByFlag, "group-by", "category", "Group by category, merchant, account, day, week or month")
+	addTransactionFilterFlags(summaryCommand)
+	addConvertFlag(summaryCommand)
+
+	rulesCommand := &cobra.Command{
+		Use:   "rules",
+		Short: "Work with the rules that rename, recategorize, tag and drop transactions",
+		Long: `Work with the rules that rename, recategorize, tag and drop transactions.
+
+Rules live in rules.toml, rules.yaml or rules.yml in the data directory, or the
+profile's with --profile. They're tried in order and the first one matching a
+transaction is applied to it, before filters and output formats. See the README for the format.`,
+	}
+
+	var rulesFromFlag string
+	var rulesToFlag string
+	var rulesAccountID string
+	var rulesFileFlag string
+	var rulesFormat string
+	rulesTestCommand := &cobra.Command{
+		Use:         "test [ITEM-ID-OR-ALIAS]",
+		Short:       "Show which rule matches each transaction",
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			itemOrAlias := args[0]
+			itemID, ok := data.Aliases[itemOrAlias]
+			if ok {
+				itemOrAlias = itemID
+			}
+
+			dateRange, err := plaid_cli.ResolveDateRange(rulesFromFlag, rulesToFlag, time.Now())
+			if err != nil {
+				fatal(err)
+			}
+
+			var rules plaid_cli.Rules
+			if rulesFileFlag != "" {
+				rules, err = plaid_cli.ReadRulesFile(rulesFileFlag)
+			} else {
+				rules, err = plaid_cli.LoadRules(data.DataDir)
+			}
+			if err != nil {
+				fatal(err)
+			}
+
+			if rulesFormat != "table" && rulesFormat != "json" {
+				fatal(fmt.Errorf("Invalid output format %q. Choose table or json.", rulesFormat))
+			}
+
+			transactions, err := fetchTransactions(itemOrAlias, dateRange, rulesAccountID)
+			if err != nil {
+				fatal(err)
+			}
+
+			if err := printRuleMatches(os.Stdout, rules, transactions, rulesFormat); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	rulesTestCommand.Flags().StringVarP(&rulesFromFlag, "from", "f", "30d", "Date of first transaction, or a named range")
+	rulesTestCommand.Flags().StringVarP(&rulesToFlag, "to", "t", "", "Date of last transaction (default today, or the end of the --from range)")
+	rulesTestCommand.Flags().StringVarP(&rulesAccountID, "account-id", "a", "", "Test transactions for this account ID only.")
+	rulesTestCommand.Flags().StringVar(&rulesFileFlag, "file", "", "Test this rules file instead of the one in the data directory")
+	rulesTestCommand.Flags().StringVarP(&rulesFormat, "output-format", "o", "table", "Output format (table or json)")
+	rulesCommand.AddCommand(rulesTestCommand)
+
+	var recurringFromFlag string
+	var recurringToFlag string
+	var recurringSourceFlag string
+	var recurringToleranceFlag float64
+	var recurringInflowsFlag bool
+	var recurringFormat string
+	recurringCommand := &cobra.Command{
+		Use:   "recurring [ITEM-ID-OR-ALIAS...]",
+		Short: "Find recurring transactions like subscriptions",
+		Long: `Find recurring transactions like subscriptions, for the given institutions or
+all of them.
+
+With --source auto, Plaid's recurring transactions are used when they're enabled
+for your Plaid account, and plaid-cli detects recurring transactions in the
+history itself otherwise. Local detection groups transactions by merchant, after
+rules are applied, and looks for weekly, biweekly, monthly, quarterly or annual
+cadences with amounts that stay within --amount-tolerance.
+
+Streams whose next charge is overdue are flagged as missed, and streams that
+started recently as new.`,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			dateRange, err := plaid_cli.ResolveDateRange(recurringFromFlag, recurringToFlag, time.Now())
+			if err != nil {
+				fatal(err)
+			}
+
+			switch recurringSourceFlag {
+			case "auto", "plaid", "local":
+			default:
+				fatal(fmt.Errorf("Invalid --source %q. Choose auto, plaid or local.", recurringSourceFlag))
+			}
+
+			if recurringFormat != "table" && recurringFormat != "json" {
+				fatal(fmt.Errorf("Invalid output format %q. Choose table or json.", recurringFormat))
+			}
+
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			opts := plaid_cli.RecurringOptions{
+				AmountTolerance: recurringToleranceFlag,
+				IncludeInflows:  recurringInflowsFlag,
+				History:         dateRange,
+				Now:             time.Now(),
+			}
+
+			streams := []*plaid_cli.RecurringStream{}
+			for _, itemID := range resolveItems(data, args) {
+				var itemStreams []*plaid_cli.RecurringStream
+
+				if recurringSourceFlag != "local" {
+					err = WithItemErrorHandling(itemID, data, linker, itemEnv, func() error {
+						var err error
+						itemStreams, err = plaid_cli.GetRecurring(client, credentials, data.Tokens[itemID], opts)
+						return err
+					})
+
+					var plaidErr plaid.Error
+					if err != nil && recurringSourceFlag == "auto" && errors.As(err, &plaidErr) {
+						log.Printf("Plaid's recurring transactions aren't available for %s (%s). Detecting them from the transaction history.", itemID, plaidErr.ErrorCode)
+					} else if err != nil {
+						fatal(err)
+					}
+				}
+
+				if recurringSourceFlag == "local" || err != nil {
+					transactions, err := fetchTransactions(itemID, dateRange, "")
+					if err != nil {
+						fatal(err)
+					}
+
+					transactions = data.Annotate(rules.Apply(transactions))
+					itemStreams = plaid_cli.DetectRecurring(transactions, opts)
+				}
+
+				for _, s := range itemStreams {
+					s.ItemID = itemID
+				}
+				streams = append(streams, itemStreams...)
+			}
+
+			plaid_cli.SortRecurring(streams)
+
+			if recurringFormat == "json" {
+				b, err := json.MarshalIndent(streams, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(b))
+				return
+			}
+
+			printRecurring(os.Stdout, data, streams)
+		},
+	}
+	recurringCommand.Flags().StringVarP(&recurringFromFlag, "from", "f", "1y", "Start of the history to analyze, or a named range")
+	recurringCommand.Flags().StringVarP(&recurringToFlag, "to", "t", "", "End of the history to analyze (default today, or the end of the --from range)")
+	recurringCommand.Flags().StringVar(&recurringSourceFlag, "source", "auto", "Where recurring transactions come from: auto, plaid or local")
+	recurringCommand.Flags().Float64Var(&recurringToleranceFlag, "amount-tolerance", 0.2, "How much an amount may change between charges, as a fraction, for local detection")
+	recurringCommand.Flags().BoolVar(&recurringInflowsFlag, "include-inflows", false, "Also find recurring money coming in, like salaries")
+	recurringCommand.Flags().StringVarP(&recurringFormat, "output-format", "o", "table", "Output format (table or json)")
+
+	var budgetMonthFlag string
+	var budgetFormat string
+	budgetCommand := &cobra.Command{
+		Use:   "budget [ITEM-ID-OR-ALIAS...]",
+		Short: "Compare this month's spending against your budgets",
+		Long: `Compare a month's spending against the budgets in the config file, across
+the given institutions or all of them.
+
+Budgets are defined under budgets.NAME with a category or a tag, a monthly
+limit, and optionally rollover and alert_percent:
+
+  [budgets.groceries]
+  category = "Food and Drink > Groceries"
+  limit = 400
+  rollover = true
+
+When a budget reaches its alert threshold (budget.alert_percent, 100 by
+default), budget.notify_command is run with the alerting budgets as JSON on
+stdin, and plaid-cli exits with status 7.`,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			now := time.Now()
+			month, err := plaid_cli.ParseMonth(budgetMonthFlag, now)
+			if err != nil {
+				fatal(err)
+			}
+			if month.From.After(now) {
+				fatal(fmt.Errorf("%s hasn't started yet.", month.From.Format(plaid_cli.MonthFormat)))
+			}
+
+			if budgetFormat != "table" && budgetFormat != "json" {
+				fatal(fmt.Errorf("Invalid output format %q. Choose table or json.", budgetFormat))
+			}
+
+			var config map[string]plaid_cli.Budget
+			if err := viper.UnmarshalKey("budgets", &config); err != nil {
+				fatal(plaid_cli.NewConfigError("Invalid budgets: %s", err))
+			}
+			budgets, err := plaid_cli.NewBudgets(config)
+			if err != nil {
+				fatal(err)
+			}
+			if len(budgets) == 0 {
+				fatal(plaid_cli.NewConfigError("No budgets are configured. See `plaid-cli budget --help`."))
+			}
+
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			converter, err := converterFromFlags(cmd, data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			// Rollover needs last month's spending too.
+			dateRange := month
+			for _, b := range budgets {
+				if b.Rollover {
+					dateRange.From = month.From.AddDate(0, -1, 0)
+				}
+			}
+			if dateRange.To.After(now) {
+				dateRange.To = now
+			}
+
+			var transactions []plaid_cli.Transaction
+			for _, itemID := range resolveItems(data, args) {
+				itemTransactions, err := fetchTransactions(itemID, dateRange, "")
+				if err != nil {
+					fatal(err)
+				}
+				transactions = append(transactions, itemTransactions...)
+			}
+			transactions = data.Annotate(rules.Apply(transactions))
+			if err := converter.Convert(transactions); err != nil {
+				fatal(err)
+			}
+
+			var budgeted []plaid_cli.Transaction
+			for _, tx := range transactions {
+				for _, b := range budgets {
+					if b.Matches(tx) {
+						budgeted = append(budgeted, tx)
+						break
+					}
+				}
+			}
+			if err := plaid_cli.CheckSingleCurrency(budgeted); err != nil {
+				fatal(err)
+			}
+
+			statuses := plaid_cli.EvaluateBudgets(budgets, transactions, month, now, viper.GetInt("budget.alert_percent"))
+
+			if budgetFormat == "json" {
+				b, err := json.MarshalIndent(statuses, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(b))
+			} else {
+				printBudgets(os.Stdout, statuses)
+			}
+
+			var alerts []plaid_cli.BudgetNotification
+			for _, s := range statuses {
+				if s.Alerting() {
+					alerts = append(alerts, plaid_cli.BudgetNotification{BudgetStatus: s, Message: s.Describe()})
+				}
+			}
+			if len(alerts) == 0 {
+				return
+			}
+
+			if command := viper.GetString("budget.notify_command"); command != "" {
+				input, err := json.Marshal(alerts)
+				if err != nil {
+					fatal(err)
+				}
+				if err := plaid_cli.RunHook(command, input, viper.GetDuration("cli.hook_timeout")); err != nil {
+					fatal(err)
+				}
+			}
+
+			os.Exit(plaid_cli.ExitAlert)
+		},
+	}
+	budgetCommand.Flags().StringVarP(&budgetMonthFlag, "month", "m", "this-month", "Month to check, as YYYY-MM, this-month or last-month")
+	budgetCommand.Flags().StringVarP(&budgetFormat, "output-format", "o", "table", "Output format (table or json)")
+	addConvertFlag(budgetCommand)
+
+	var reportMonthFlag string
+	var reportHTMLFlag string
+	reportCommand := &cobra.Command{
+		Use:   "report [ITEM-ID-OR-ALIAS...]",
+		Short: "Write a monthly spending report as HTML",
+		Long: `Write a monthly spending report across the given institutions or all of
+them: spending by category, top merchants, income and expenses, and each
+account's balance over the month, compared with the previous month.
+
+Transfers are left out. With --detect-transfers, outflows paired with a matching
+inflow at another covered institution count as transfers too.
+
+The report is a single HTML file with inline charts, so it can be opened
+offline or sent by email. Balances are traced back from today's balances
+through the transactions since.`,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			now := time.Now()
+			month, err := plaid_cli.ParseMonth(reportMonthFlag, now)
+			if err != nil {
+				fatal(err)
+			}
+			if month.From.After(now) {
+				fatal(fmt.Errorf("%s hasn't started yet.", month.From.Format(plaid_cli.MonthFormat)))
+			}
+
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			converter, err := converterFromFlags(cmd, data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			// The previous month is needed for the comparison, and everything
+			// up to today to trace balances back.
+			dateRange := plaid_cli.DateRange{From: month.From.AddDate(0, -1, 0), To: now}
+
+			var transactions []plaid_cli.Transaction
+			var accounts []plaid_cli.ReportAccount
+			for _, itemID := range resolveItems(data, args) {
+				err := WithItemErrorHandling(itemID, data, linker, itemEnv, func() error {
+					res, err := client.GetAccounts(data.Tokens[itemID])
+					if err != nil {
+						return err
+					}
+					for _, account := range res.Accounts {
+						accounts = append(accounts, plaid_cli.ReportAccount{ItemID: itemID, Account: account})
+					}
+					return nil
+				})
+				if err != nil {
+					fatal(err)
+				}
+
+				itemTransactions, err := fetchTransactions(itemID, dateRange, "")
+				if err != nil {
+					fatal(err)
+				}
+				transactions = append(transactions, itemTransactions...)
+			}
+			transactions = data.Annotate(rules.Apply(transactions))
+			if detect, _ := cmd.Flags().GetBool("detect-transfers"); detect {
+				plaid_cli.PairTransfers(transactions, nil, viper.GetInt("transfers.window_days"))
+			}
+			if err := converter.Convert(transactions); err != nil {
+				fatal(err)
+			}
+			if err := plaid_cli.CheckSingleCurrency(transactions); err != nil {
+				fatal(err)
+			}
+
+			report := plaid_cli.BuildReport(transactions, accounts, month, now)
+
+			out, err := plaid_cli.OpenOutput(reportHTMLFlag)
+			if err != nil {
+				fatal(err)
+			}
+			if err := report.WriteHTML(out); err != nil {
+				out.Abort()
+				fatal(err)
+			}
+			if err := out.Commit(); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	reportCommand.Flags().StringVarP(&reportMonthFlag, "month", "m", "last-month", "Month to report on, as YYYY-MM, this-month or last-month")
+	reportCommand.Flags().StringVar(&reportHTMLFlag, "html", "", "Write the HTML report to this file instead of stdout")
+	reportCommand.Flags().Bool("detect-transfers", false, "Leave out transfers between the covered institutions, found by pairing matching transactions")
+	addConvertFlag(reportCommand)
+
+	var anomaliesFromFlag string
+	var anomaliesHistoryFlag string
+	var anomaliesFormat string
+	var anomaliesNewOnlyFlag bool
+	anomaliesCommand := &cobra.Command{
+		Use:   "anomalies [ITEM-ID-OR-ALIAS...]",
+		Short: "Flag unusual charges",
+		Long: `Flag recent charges that stand out from the history before them, across the
+given institutions or all of them:
+
+  large         far above the usual amount at the merchant, or in the category
+                (anomalies.large_factor times the median, 3 by default)
+  new_merchant  a first charge from a merchant of at least
+                anomalies.new_merchant_amount (100 by default)
+  duplicate     the same amount at the same merchant on the same account within
+                anomalies.duplicate_days (1 by default)
+  foreign       charged in another currency than the account's usual one, or
+                outside plaid.countries
+
+Transfers between accounts and money coming in are never flagged. Transfers are
+what Plaid categorizes as such and, with --detect-transfers, outflows paired
+with a matching inflow at another checked institution.
+
+When anything is flagged, anomalies.notify_command is run with the anomalies
+as JSON on stdin, and plaid-cli exits with status 7. With --new-only, anomalies
+reported by a previous --new-only run are skipped, so it can run from cron.`,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			if anomaliesFormat != "table" && anomaliesFormat != "json" {
+				fatal(fmt.Errorf("Invalid output format %q. Choose table or json.", anomaliesFormat))
+			}
+
+			now := time.Now()
+			window, err := plaid_cli.ResolveDateRange(anomaliesFromFlag, "", now)
+			if err != nil {
+				fatal(err)
+			}
+			// The history is relative to the start of the checked range.
+			historyFrom, err := plaid_cli.ParseDate(anomaliesHistoryFlag, window.From)
+			if err != nil {
+				fatal(err)
+			}
+
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			accountItems := map[string]string{}
+			var transactions []plaid_cli.Transaction
+			for _, itemID := range resolveItems(data, args) {
+				itemTransactions, err := fetchTransactions(itemID, plaid_cli.DateRange{From: historyFrom, To: window.To}, "")
+				if err != nil {
+					fatal(err)
+				}
+				for _, tx := range itemTransactions {
+					accountItems[tx.AccountID] = itemID
+				}
+				transactions = append(transactions, itemTransactions...)
+			}
+			transactions = data.Annotate(rules.Apply(transactions))
+			if detect, _ := cmd.Flags().GetBool("detect-transfers"); detect {
+				plaid_cli.PairTransfers(transactions, nil, viper.GetInt("transfers.window_days"))
+			}
+			plaid_cli.MarkTransfers(transactions)
+
+			start := window.From.Format(plaid_cli.DateFormat)
+			var checked, history []plaid_cli.Transaction
+			for _, tx := range transactions {
+				if tx.Date >= start {
+					checked = append(checked, tx)
+				} else {
+					history = append(history, tx)
+				}
+			}
+
+			opts := plaid_cli.AnomalyOptions{
+				LargeFactor:       viper.GetFloat64("anomalies.large_factor"),
+				NewMerchantAmount: viper.GetFloat64("anomalies.new_merchant_amount"),
+				DuplicateDays:     viper.GetInt("anomalies.duplicate_days"),
+				Countries:         viper.GetStringSlice("plaid.countries"),
+			}
+
+			anomalies := []plaid_cli.Anomaly{}
+			for _, a := range plaid_cli.DetectAnomalies(checked, history, opts) {
+				a.ItemID = accountItems[a.Transaction.AccountID]
+				if anomaliesNewOnlyFlag {
+					if _, ok := data.ReportedAnomalies[a.Key()]; ok {
+						continue
+					}
+				}
+				anomalies = append(anomalies, a)
+			}
+
+			if anomaliesNewOnlyFlag {
+				// Transactions before the checked range can't be flagged
+				// again, so they don't need to be remembered.
+				for key, date := range data.ReportedAnomalies {
+					if date < start {
+						delete(data.ReportedAnomalies, key)
+					}
+				}
+				for _, a := range anomalies {
+					data.ReportedAnomalies[a.Key()] = a.Transaction.Date
+				}
+				if err := data.SaveReportedAnomalies(); err != nil {
+					fatal(err)
+				}
+			}
+
+			if anomaliesFormat == "json" {
+				b, err := json.MarshalIndent(anomalies, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(b))
+			} else {
+				printAnomalies(os.Stdout, data, anomalies)
+			}
+
+			if len(anomalies) == 0 {
+				return
+			}
+
+			if command := viper.GetString("anomalies.notify_command"); command != "" {
+				input, err := json.Marshal(anomalies)
+				if err != nil {
+					fatal(err)
+				}
+				if err := plaid_cli.RunHook(command, input, viper.GetDuration("cli.hook_timeout")); err != nil {
+					fatal(err)
+				}
+			}
+
+			os.Exit(plaid_cli.ExitAlert)
+		},
+	}
+	anomaliesCommand.Flags().StringVarP(&anomaliesFromFlag, "from", "f", "7d", "Check charges from this date on, or in a named range")
+	anomaliesCommand.Flags().StringVar(&anomaliesHistoryFlag, "history", "6m", "How far back before --from to look for usual amounts and known merchants")
+	anomaliesCommand.Flags().StringVarP(&anomaliesFormat, "output-format", "o", "table", "Output format (table or json)")
+	anomaliesCommand.Flags().BoolVar(&anomaliesNewOnlyFlag, "new-only", false, "Skip anomalies reported by a previous --new-only run")
+	anomaliesCommand.Flags().Bool("detect-transfers", false, "Don't flag transfers between the checked institutions, found by pairing matching transactions")
+
+	var watchIntervalFlag time.Duration
+	var watchHookFlag string
+	var watchOnceFlag bool
+	var watchEmitExistingFlag bool
+	watchCommand := &cobra.Command{
+		Use:   "watch [ITEM-ID-OR-ALIAS...]",
+		Short: "Poll for new and changed transactions and emit them as events",
+		Long: `Poll the given institutions, or all of them, for new, modified and removed
+transactions and emit an event for each, as NDJSON on stdout:
+
+  {"type":"added","item_id":"...","transaction_id":"...","transaction":{...}}
+
+With --hook (or sync.hook_command), the command is run once per event instead,
+with the event as JSON on stdin.
+
+Changes are fetched with Plaid's transactions sync, and where each institution
+is at is saved in the data directory, so restarting doesn't emit the same
+events again. The first time an institution is watched, only its current
+state is recorded, unless --emit-existing is given.
+
+Nothing prompts to relink while watching: institutions that need it are
+logged with how to fix them, and tried again on the next poll.`,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			if watchIntervalFlag <= 0 {
+				fatal(errors.New("--interval must be positive"))
+			}
+
+			// Nobody may be around to answer a prompt, and an institution
+			// that keeps failing would prompt on every poll.
+			viper.Set("cli.no_relink", true)
+
+			rules, err := plaid_cli.LoadRules(data.DataDir)
+			if err != nil {
+				fatal(err)
+			}
+
+			hook := viper.GetString("sync.hook_command")
+			if watchHookFlag != "" {
+				hook = watchHookFlag
+			}
+
+			emit := newEventEmitter(hook)
+
+			ctx, stop := interruptContext()
+			defer stop()
+
+			items := resolveItems(data, args)
+			for {
+				var lastErr error
+				for _, itemID := range items {
+					if err := syncItem(itemID, rules, watchEmitExistingFlag, emit); err != nil {
+						log.Printf("Syncing %s failed: %s", itemID, err)
+						lastErr = err
+					}
+				}
+
+				if watchOnceFlag {
+					if lastErr != nil {
+						fatal(lastErr)
+					}
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(watchIntervalFlag):
+				}
+			}
+		},
+	}
+	watchCommand.Flags().DurationVar(&watchIntervalFlag, "interval", time.Hour, "Time between polls")
+	watchCommand.Flags().StringVar(&watchHookFlag, "hook", "", "Run this command for each event, with the event as JSON on stdin, instead of printing it")
+	watchCommand.Flags().BoolVar(&watchOnceFlag, "once", false, "Poll once and exit")
+	watchCommand.Flags().BoolVar(&watchEmitExistingFlag, "emit-existing", false, "Emit existing transactions the first time an institution is watched")
+
+	webhookCommand := &cobra.Command{
+		Use:   "webhook",
+		Short: "Receive webhooks from Plaid",
+	}
+
+	var webhookAddrFlag string
+	var webhookHookFlag string
+	webhookServeCommand := &cobra.Command{
+		Use:   "serve",
+		Short: "Receive Plaid webhooks and sync institutions when they have updates",
+		Long: `Listen for Plaid webhooks and act on them, instead of polling with watch.
+
+Plaid has to be able to reach the server, so it usually runs behind a reverse
+proxy or tunnel. Give its public URL to Plaid with link --webhook when linking
+an institution, or with item webhook update for institutions already linked.
+
+Every webhook's signature is checked against Plaid's verification keys, and
+unsigned or stale webhooks are rejected. Then:
+
+  TRANSACTIONS SYNC_UPDATES_AVAILABLE  syncs the institution and emits an event
+                                       per change, like watch
+  ITEM ERROR                           logs the error and how to fix it
+  ITEM PENDING_EXPIRATION              logs that the institution needs relinking
+  ITEM NEW_ACCOUNTS_AVAILABLE          logs how to share the new accounts
+
+Sync events are written as NDJSON on stdout or, with --hook (or
+sync.hook_command), passed to the command on stdin. Every webhook is also
+passed as JSON to webhook.hook_command, if set.
+
+Nothing prompts to relink while serving: institutions that need it are logged.`,
+		Args:        cobra.NoArgs,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			if _, err := plaid_cli.LoadRules(data.DataDir); err != nil {
+				fatal(err)
+			}
+
+			// There's nobody to answer a prompt.
+			viper.Set("cli.no_relink", true)
+
+			syncHook := viper.GetString("sync.hook_command")
+			if webhookHookFlag != "" {
+				syncHook = webhookHookFlag
+			}
+			emit := newEventEmitter(syncHook)
+			webhookHook := viper.GetString("webhook.hook_command")
+
+			// Webhooks are handled one at a time, after Plaid got its
+			// answer, as syncing can take longer than Plaid waits.
+			queue := make(chan plaid_cli.Webhook, 100)
+			enqueue := func(webhook plaid_cli.Webhook) error {
+				select {
+				case queue <- webhook:
+					return nil
+				default:
+					return errors.New("too many webhooks queued")
+				}
+			}
+
+			// Data and rules are reloaded for every webhook, to pick up
+			// links, aliases, rules and sync positions changed by other
+			// commands meanwhile. Data isn't safe for concurrent use, so mu
+			// is held while it's used.
+			var mu sync.Mutex
+			handle := func(webhook plaid_cli.Webhook) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				reloaded, err := plaid_cli.LoadData(data.DataDir)
+				if err != nil {
+					log.Printf("Couldn't handle %s webhook for %s: %s", webhook, webhook.ItemID, err)
+					return
+				}
+				data = reloaded
+				linker.Data = reloaded
+				rules, err := plaid_cli.LoadRules(data.DataDir)
+				if err != nil {
+					log.Printf("Couldn't handle %s webhook for %s: %s", webhook, webhook.ItemID, err)
+					return
+				}
+
+				itemID := webhook.ItemID
+				if _, ok := data.Tokens[itemID]; !ok {
+					log.Printf("Ignoring %s webhook for unknown item %s.", webhook, itemID)
+					return
+				}
+
+				switch webhook.String() {
+				case plaid_cli.WebhookTransactions + " " + plaid_cli.WebhookSyncUpdates:
+					if err := syncItem(itemID, rules, false, emit); err != nil {
+						log.Printf("Syncing %s failed: %s", itemID, err)
+					}
+				case plaid_cli.WebhookItem + " " + plaid_cli.WebhookError:
+					if webhook.Error == nil {
+						log.Printf("Plaid reported an error for %s.", itemID)
+					} else if itemErr := plaid_cli.ClassifyError(itemID, *webhook.Error); itemErr != nil {
+						log.Println(itemErr)
+					}
+				case plaid_cli.WebhookItem + " " + plaid_cli.WebhookPendingExpiration:
+					log.Printf("Access to %s expires %s. Run `plaid-cli link %s` to renew it.", itemID, webhook.ConsentExpirationTime, itemID)
+				case plaid_cli.WebhookItem + " " + plaid_cli.WebhookNewAccounts:
+					log.Printf("%s has new accounts. Run `plaid-cli link --account-selection %s` to share them.", itemID, itemID)
+				default:
+					log.Printf("Received %s webhook for %s.", webhook, itemID)
+				}
+
+				if webhookHook != "" {
+					if err := plaid_cli.RunHook(webhookHook, webhook.Body, viper.GetDuration("cli.hook_timeout")); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+
+			ctx, stop := interruptContext()
+			defer stop()
+
+			server := &http.Server{
+				Addr:    webhookAddrFlag,
+				Handler: plaid_cli.WebhookHandler(plaid_cli.NewWebhookVerifier(client), enqueue),
+			}
+			errs := make(chan error, 1)
+			go func() {
+				errs <- server.ListenAndServe()
+			}()
+			log.Printf("Listening for webhooks on %s...", webhookAddrFlag)
+
+			for {
+				select {
+				case webhook := <-queue:
+					handle(webhook)
+				case err := <-errs:
+					fatal(err)
+				case <-ctx.Done():
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := server.Shutdown(shutdownCtx); err != nil {
+						fatal(err)
+					}
+					return
+				}
+			}
+		},
+	}
+	webhookServeCommand.Flags().StringVar(&webhookAddrFlag, "addr", ":9000", "Address to listen on")
+	webhookServeCommand.Flags().StringVar(&webhookHookFlag, "hook", "", "Run this command for each sync event, with the event as JSON on stdin, instead of printing it")
+	webhookCommand.AddCommand(webhookServeCommand)
+
+	itemCommand := &cobra.Command{
+		Use:   "item",
+		Short: "Manage linked institutions at Plaid",
+	}
+
+	itemWebhookCommand := &cobra.Command{
+		Use:   "webhook",
+		Short: "Manage the webhook of linked institutions",
+	}
+
+	itemWebhookUpdateCommand := &cobra.Command{
+		Use:   "update ITEM-ID-OR-ALIAS URL",
+		Short: "Set the URL Plaid sends an institution's webhooks to",
+		Long: `Set the URL Plaid sends an institution's webhooks to, e.g. the public URL of
+webhook serve. Institutions linked with link --webhook already have one.`,
+		Args:        cobra.ExactArgs(2),
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			itemOrAlias := args[0]
+			itemID, ok := data.Aliases[itemOrAlias]
+			if ok {
+				itemOrAlias = itemID
+			}
+
+			err := WithItemErrorHandling(itemOrAlias, data, linker, itemEnv, func() error {
+				_, err := client.UpdateItemWebhook(data.Tokens[itemOrAlias], args[1])
+				return err
+			})
+			if err != nil {
+				fatal(err)
+			}
+
+			log.Printf("Webhook for %s set to %s.", args[0], args[1])
+		},
+	}
+	itemWebhookCommand.AddCommand(itemWebhookUpdateCommand)
+	itemCommand.AddCommand(itemWebhookCommand)
+
+	var serveAddrFlag string
+	serveCommand := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a local HTTP JSON API for scripts and dashboards",
+		Long: `Serve linked institutions over a local HTTP JSON API, so scripts and dashboards
+can query plaid-cli instead of parsing its output:
+
+  GET  /items                       linked institutions and their aliases
+  GET  /items/ITEM/accounts         accounts, like the accounts command
+  GET  /items/ITEM/balances         accounts with real-time balances
+  GET  /items/ITEM/transactions     transactions, like the transactions command
+  POST /items/ITEM/sync             sync transactions and return the changes
+
+ITEM is an item ID or alias. Transactions take the transactions flags as query
+parameters, e.g. ?from=2026-01-01&category=Travel&output-format=csv, and rules,
+tags and notes are applied as on the command line. Sync takes emit-existing and
+shares its position with watch and webhook serve, even while they run, so
+changes returned by one aren't returned by the others unless they sync the
+same institution at the same time. The position only moves on once the
+response was sent, so changes are returned again if it couldn't be.
+
+Every request needs an Authorization: Bearer header with serve.token. If it
+isn't set, a random token is generated and logged at startup. The server only
+listens on localhost unless --addr says otherwise.
+
+Errors are returned as the JSON that --error-format json prints. Nothing
+prompts to relink: institutions that need it get a 409. Nothing waits to
+retry either: institutions that are temporarily unavailable get a 503.`,
+		Args:        cobra.NoArgs,
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			token := viper.GetString("serve.token")
+			if token == "" {
+				var err error
+				token, err = plaid_cli.NewAPIToken()
+				if err != nil {
+					fatal(err)
+				}
+				log.Printf("serve.token isn't set. Authenticate with: Authorization: Bearer %s", token)
+			}
+
+			// There's nobody to answer a prompt.
+			viper.Set("cli.no_relink", true)
+
+			dataDir := data.DataDir
+			// syncing holds the items being synced, guarded by syncingMu.
+			var syncingMu sync.Mutex
+			syncing := map[string]bool{}
+
+			notFound := func(w http.ResponseWriter, format string, args ...interface{}) {
+				plaid_cli.WriteAPIError(w, plaid_cli.NewBadRequestError(fmt.Errorf(format, args...)), http.StatusNotFound)
+			}
+			allowMethod := func(w http.ResponseWriter, r *http.Request, method string) bool {
+				if r.Method == method {
+					return true
+				}
+				w.Header().Set("Allow", method)
+				plaid_cli.WriteAPIError(w, plaid_cli.NewBadRequestError(fmt.Errorf("Use %s for %s", method, r.URL.Path)), http.StatusMethodNotAllowed)
+				return false
+			}
+
+			serveTransactions := func(w http.ResponseWriter, r *http.Request, d *plaid_cli.Data, itemID string) error {
+				query := &cobra.Command{}
+				query.Flags().String("from", "30d", "")
+				query.Flags().String("to", "", "")
+				query.Flags().String("account-id", "", "")
+				query.Flags().String("sort", "", "")
+				query.Flags().String("output-format", "json", "")
+				addTransactionFilterFlags(query)
+				addConvertFlag(query)
+				if err := setFlagsFromQuery(query, r.URL.Query()); err != nil {
+					return plaid_cli.NewBadRequestError(err)
+				}
+
+				flags := query.Flags()
+				from, _ := flags.GetString("from")
+				to, _ := flags.GetString("to")
+				account, _ := flags.GetString("account-id")
+				sortBy, _ := flags.GetString("sort")
+				format, _ := flags.GetString("output-format")
+
+				dateRange, err := plaid_cli.ResolveDateRange(from, to, time.Now())
+				if err != nil {
+					return plaid_cli.NewBadRequestError(err)
+				}
+				filter, err := transactionFilterFromFlags(query)
+				if err != nil {
+					return plaid_cli.NewBadRequestError(err)
+				}
+				var out bytes.Buffer
+				serializer, err := NewTransactionSerializer(format, &out)
+				if err != nil {
+					return plaid_cli.NewBadRequestError(err)
+				}
+				converter, err := converterFromFlags(query, d.DataDir)
+				if err != nil {
+					return err
+				}
+				rules, err := plaid_cli.LoadRules(d.DataDir)
+				if err != nil {
+					return err
+				}
+
+				fetch := func(itemID string, dateRange plaid_cli.DateRange, accountID string) ([]plaid_cli.Transaction, error) {
+					transactions := []plaid_cli.Transaction{}
+					err := WithItemErrorHandling(itemID, d, linker, itemEnv, func() error {
+						return transactionPages(r.Context(), d, itemID, dateRange, accountID, func(page []plaid_cli.Transaction) error {
+							transactions = append(transactions, page...)
+							return nil
+						})
+					})
+					return transactions, err
+				}
+
+				counterparts, err := fetchCounterparts(query, d, itemID, dateRange, rules, fetch)
+				if err != nil {
+					return err
+				}
+				transactions, err := fetch(itemID, dateRange, account)
+				if err != nil {
+					return err
+				}
+				transactions, err = prepareTransactions(transactions, counterparts, converter, rules, d, filter)
+				if err != nil {
+					return err
+				}
+				if sortBy != "" {
+					if err := plaid_cli.SortTransactions(transactions, sortBy); err != nil {
+						return plaid_cli.NewBadRequestError(err)
+					}
+				}
+
+				if err := serializer.write(transactions); err != nil {
+					return err
+				}
+				if err := serializer.close(); err != nil {
+					return err
+				}
+
+				switch format {
+				case "csv":
+					w.Header().Set("Content-Type", "text/csv")
+				case "ndjson":
+					w.Header().Set("Content-Type", "application/x-ndjson")
+				default:
+					w.Header().Set("Content-Type", "application/json")
+				}
+				_, err = out.WriteTo(w)
+				return err
+			}
+
+			serveItem := func(w http.ResponseWriter, r *http.Request, d *plaid_cli.Data) error {
+				parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/items/"), "/"), "/")
+				if len(parts) != 2 {
+					notFound(w, "No such endpoint: %s", r.URL.Path)
+					return nil
+				}
+
+				itemID := parts[0]
+				if id, ok := d.Aliases[itemID]; ok {
+					itemID = id
+				}
+				if _, ok := d.Tokens[itemID]; !ok {
+					notFound(w, "No linked institution with ID or alias %s", parts[0])
+					return nil
+				}
+
+				switch parts[1] {
+				case "accounts", "balances":
+					if !allowMethod(w, r, http.MethodGet) {
+						return nil
+					}
+
+					c, err := plaid_cli.NewClientWithContext(r.Context(), clientOpts)
+					if err != nil {
+						return err
+					}
+
+					var accounts []plaid.Account
+					err = WithItemErrorHandling(itemID, d, linker, itemEnv, func() error {
+						if parts[1] == "balances" {
+							res, err := c.GetBalances(d.Tokens[itemID])
+							accounts = res.Accounts
+							return err
+						}
+						res, err := c.GetAccounts(d.Tokens[itemID])
+						accounts = res.Accounts
+						return err
+					})
+					if err != nil {
+						return err
+					}
+
+					plaid_cli.WriteJSON(w, http.StatusOK, accounts)
+					return nil
+				case "transactions":
+					if !allowMethod(w, r, http.MethodGet) {
+						return nil
+					}
+
+					return serveTransactions(w, r, d, itemID)
+				case "sync":
+					if !allowMethod(w, r, http.MethodPost) {
+						return nil
+					}
+
+					emitExisting := false
+					if v := r.URL.Query().Get("emit-existing"); v != "" {
+						var err error
+						if emitExisting, err = strconv.ParseBool(v); err != nil {
+							return plaid_cli.NewBadRequestError(fmt.Errorf("Invalid emit-existing: %s", err))
+						}
+					}
+					rules, err := plaid_cli.LoadRules(d.DataDir)
+					if err != nil {
+						return err
+					}
+					c, err := plaid_cli.NewClientWithContext(r.Context(), clientOpts)
+					if err != nil {
+						return err
+					}
+
+					// Two syncs from the same cursor would return the
+					// same changes.
+					syncingMu.Lock()
+					busy := syncing[itemID]
+					syncing[itemID] = true
+					syncingMu.Unlock()
+					if busy {
+						plaid_cli.WriteAPIError(w, plaid_cli.NewBadRequestError(fmt.Errorf("%s is already being synced", parts[0])), http.StatusConflict)
+						return nil
+					}
+					defer func() {
+						syncingMu.Lock()
+						delete(syncing, itemID)
+						syncingMu.Unlock()
+					}()
+
+					events, cursor, err := syncChanges(c, d, itemID, rules, emitExisting)
+					if err != nil {
+						return err
+					}
+
+					// The cursor is only saved once the changes were sent, so
+					// they're returned again if the client didn't get them.
+					if err := plaid_cli.WriteJSON(w, http.StatusOK, events); err != nil {
+						log.Printf("Couldn't send the changes of %s, they'll be returned again: %s", itemID, err)
+						return nil
+					}
+
+					dataMu.Lock()
+					err = d.SaveSyncCursor(itemID, cursor)
+					dataMu.Unlock()
+					if err != nil {
+						log.Printf("Couldn't save the sync cursor of %s: %s", itemID, err)
+					}
+					return nil
+				default:
+					notFound(w, "No such endpoint: %s", r.URL.Path)
+					return nil
+				}
+			}
+
+			serveItems := func(w http.ResponseWriter, r *http.Request, d *plaid_cli.Data) error {
+				if !allowMethod(w, r, http.MethodGet) {
+					return nil
+				}
+
+				items := []apiItem{}
+				for _, itemID := range resolveItems(d, nil) {
+					item := apiItem{ItemID: itemID, Alias: d.BackAliases[itemID]}
+					if env, ok := d.Items[itemID]; ok {
+						item.Environment = env.Environment
+						// Older versions wrote a zero time for items
+						// without one.
+						if env.LinkedAt != nil && !env.LinkedAt.IsZero() {
+							item.LinkedAt = env.LinkedAt
+						}
+					}
+					items = append(items, item)
+				}
+
+				plaid_cli.WriteJSON(w, http.StatusOK, items)
+				return nil
+			}
+
+			// Data isn't safe for concurrent use, so each request gets its
+			// own copy, loaded when it comes in to pick up links, aliases
+			// and tags made with other commands meanwhile. dataMu keeps
+			// loads from reading a file while another request writes it.
+			handle := func(fn func(w http.ResponseWriter, r *http.Request, d *plaid_cli.Data) error) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					dataMu.Lock()
+					d, err := plaid_cli.LoadData(dataDir)
+					dataMu.Unlock()
+
+					if err == nil {
+						err = fn(w, r, d)
+					}
+					if err != nil {
+						plaid_cli.WriteAPIError(w, err, 0)
+					}
+				})
+			}
+
+			mux := http.NewServeMux()
+			mux.Handle("/items", handle(serveItems))
+			mux.Handle("/items/", handle(serveItem))
+
+			ctx, stop := interruptContext()
+			defer stop()
+
+			server := &http.Server{
+				Addr:    serveAddrFlag,
+				Handler: plaid_cli.RequireBearerToken(token, mux),
+			}
+			errs := make(chan error, 1)
+			go func() {
+				errs <- server.ListenAndServe()
+			}()
+			log.Printf("Serving the API on http://%s...", serveAddrFlag)
+
+			select {
+			case err := <-errs:
+				fatal(err)
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := server.Shutdown(shutdownCtx); err != nil {
+					fatal(err)
+				}
+			}
+		},
+	}
+	serveCommand.Flags().StringVar(&serveAddrFlag, "addr", "127.0.0.1:8765", "Address to listen on")
+
+	var removeTagsFlag bool
+	var reimbursableFlag bool
+	tagCommand := &cobra.Command{
+		Use:   "tag [TRANSACTION-ID] [TAG...]",
+		Short: "Tag a transaction",
+		Long: `Tag a transaction, or mark it reimbursable.
+
+Tags are kept in the data directory, keyed by transaction ID, and show up in
+transactions output, where --tag and --reimbursable filter on them. Without
+tags or flags, the transaction's tags are printed.`,
+		Args:        cobra.MinimumNArgs(1),
+		Annotations: requires(requiresConfig),
+		Run: func(cmd *cobra.Command, args []string) {
+			transactionID, tags := args[0], args[1:]
+			annotation := data.Annotations[transactionID]
+
+			if len(tags) == 0 && !cmd.Flags().Changed("reimbursable") {
+				for _, tag := range annotation.Tags {
+					fmt.Println(tag)
+				}
+				if annotation.Reimbursable {
+					fmt.Println("(reimbursable)")
+				}
+				return
+			}
+
+			tx := plaid_cli.Transaction{Tags: annotation.Tags}
+			if removeTagsFlag {
+				tx.RemoveTags(tags...)
+			} else {
+				tx.AddTags(tags...)
+			}
+			annotation.Tags = tx.Tags
+
+			if cmd.Flags().Changed("reimbursable") {
+				annotation.Reimbursable = reimbursableFlag
+			}
+
+			if err := data.SetAnnotation(transactionID, annotation); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	tagCommand.Flags().BoolVarP(&removeTagsFlag, "remove", "r", false, "Remove the tags instead of adding them")
+	tagCommand.Flags().BoolVar(&reimbursableFlag, "reimbursable", false, "Mark the transaction reimbursable, or not with --reimbursable=false")
+
+	var clearNoteFlag bool
+	noteCommand := &cobra.Command{
+		Use:   "note [TRANSACTION-ID] [TEXT...]",
+		Short: "Add a note to a transaction",
+		Long: `Add a note to a transaction, replacing any previous note.
+
+Notes are kept in the data directory, keyed by transaction ID, and show up in
+transactions output. Without text, the transaction's note is printed.`,
+		Args:        cobra.MinimumNArgs(1),
+		Annotations: requires(requiresConfig),
+		Run: func(cmd *cobra.Command, args []string) {
+			transactionID, text := args[0], strings.Join(args[1:], " ")
+			annotation := data.Annotations[transactionID]
+
+			if text == "" && !clearNoteFlag {
+				if annotation.Note != "" {
+					fmt.Println(annotation.Note)
+				}
+				return
+			}
+
+			annotation.Note = text
+			if err := data.SetAnnotation(transactionID, annotation); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	noteCommand.Flags().BoolVar(&clearNoteFlag, "clear", false, "Remove the note")
+
+	var withStatusFlag bool
+	var withOptionalMetadataFlag bool
+	insitutionCommand := &cobra.Command{
+		Use:         "institution [ITEM-ID-OR-ALIAS]",
+		Short:       "Get information about an institution",
+		Long:        "Get information about an institution. Status can be reported using a flag.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresAPI),
+		Run: func(cmd *cobra.Command, args []string) {
+			itemOrAlias := args[0]
+			itemID, ok := data.Aliases[itemOrAlias]
+			if ok {
+				itemOrAlias = itemID
+			}
+
+			if err := validateLocale(countries, lang); err != nil {
+				fatal(err)
+			}
+
+			err := WithItemErrorHandling(itemOrAlias, data, linker, itemEnv, func() error {
+				token := data.Tokens[itemOrAlias]
+
+				itemResp, err := client.GetItem(token)
+				if err != nil {
+					return err
+				}
+
+				instID := itemResp.Item.InstitutionID
+
+				opts := plaid.GetInstitutionByIDOptions{
+					IncludeOptionalMetadata: withOptionalMetadataFlag,
 					IncludeStatus:           withStatusFlag,
 				}
-				resp, err := client.GetInstitutionByIDWithOptions(instID, countries, opts)
+				resp, err := client.GetInstitutionByIDWithOptions(instID, countries, opts)
+				if err != nil {
+					return err
+				}
+
+				b, err := json.MarshalIndent(resp.Institution, "", "  ")
+				if err != nil {
+					return err
+				}
+
+				fmt.Println(string(b))
+
+				return nil
+			})
+
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+	insitutionCommand.Flags().BoolVarP(&withStatusFlag, "status", "s", false, "Fetch institution status")
+	insitutionCommand.Flags().BoolVarP(&withOptionalMetadataFlag, "optional-metadata", "m", false, "Fetch optional metadata like logo and URL")
+
+	var doctorFormat string
+	var consentWarningFlag time.Duration
+	var staleAfterFlag time.Duration
+	doctorCommand := &cobra.Command{
+		Use:         "doctor",
+		Short:       "Check configuration and the health of linked institutions",
+		Long:        "Check configuration, data files and the health of every linked institution. Exits with a non-zero status if a problem is found, so it can run from cron.",
+		Args:        cobra.NoArgs,
+		Annotations: requires(requiresConfig),
+		Run: func(cmd *cobra.Command, args []string) {
+			// The client is set up here rather than before the command
+			// runs, so that a configuration problem is reported as a
+			// failed check.
+			clientErr := setupClient()
+
+			report := &plaid_cli.DoctorReport{
+				Config: checkConfig(client, clientErr, countries, lang),
+				Data:   data.Verify(),
+			}
+
+			thresholds := plaid_cli.HealthThresholds{
+				ConsentWarning: consentWarningFlag,
+				StaleAfter:     staleAfterFlag,
+			}
+			now := time.Now()
+			if clientErr == nil {
+				for itemID := range data.Tokens {
+					report.Items = append(report.Items, plaid_cli.CheckItem(client, data, itemID, itemEnv, countries, thresholds, now))
+				}
+			}
+			sort.Slice(report.Items, func(i, j int) bool {
+				return report.Items[i].ItemID < report.Items[j].ItemID
+			})
+
+			switch doctorFormat {
+			case "json":
+				b, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(b))
+			case "table":
+				printDoctorReport(os.Stdout, report)
+			default:
+				fatal(fmt.Errorf("Invalid output format: %s", doctorFormat))
+			}
+
+			if !report.OK() {
+				os.Exit(plaid_cli.ExitError)
+			}
+		},
+	}
+	doctorCommand.Flags().StringVarP(&doctorFormat, "output-format", "o", "table", "Output format (table or json)")
+	doctorCommand.Flags().DurationVar(&consentWarningFlag, "consent-warning", 14*24*time.Hour, "Warn when consent expires within this duration")
+	doctorCommand.Flags().DurationVar(&staleAfterFlag, "stale-after", 72*time.Hour, "Warn when transactions haven't been updated for this long")
+
+	initCommand := &cobra.Command{
+		Use:   "init",
+		Short: "Interactively create a config file",
+		Long:  "Interactively create a config file. The credentials are checked against the Plaid API before they're saved.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := viper.GetString("cli.profile")
+			if !plaid_cli.IsValidProfileName(profile) {
+				fatal(plaid_cli.NewConfigError("Invalid profile name `%s`. Valid characters: [0-9A-Za-z_-]", profile))
+			}
+
+			err := runInit(viper.GetString("cli.data_dir"), profile)
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	configCommand := &cobra.Command{
+		Use:   "config",
+		Short: "Show and edit configuration",
+	}
+
+	var configShowFormat string
+	configShowCommand := &cobra.Command{
+		Use:         "show",
+		Short:       "Show every effective setting and where its value came from",
+		Args:        cobra.NoArgs,
+		Annotations: requires(requiresConfig),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, err := plaid_cli.ReadConfigFile(configPath)
+			if err != nil {
+				fatal(plaid_cli.NewConfigError("Error reading config file: %s", err))
+			}
+
+			type shownSetting struct {
+				Key    string `json:"key"`
+				Value  string `json:"value"`
+				Source string `json:"source"`
+			}
+
+			var shown []shownSetting
+			for _, setting := range plaid_cli.Settings {
+				var value interface{} = viper.Get(setting.Key)
+				if setting.Kind == plaid_cli.KindList {
+					value = viper.GetStringSlice(setting.Key)
+				}
+
+				entry := shownSetting{
+					Key:    setting.Key,
+					Value:  setting.Format(value),
+					Source: settingSource(cmd, setting, file, configPath, localeDefaults),
+				}
+
+				// Credential commands are only run when the Plaid API is used.
+				commandKey := setting.Key + "_command"
+				if !viper.IsSet(setting.Key) && viper.IsSet(commandKey) {
+					entry.Value = "(not run)"
+					entry.Source = "command " + commandKey
+				}
+
+				shown = append(shown, entry)
+			}
+
+			switch configShowFormat {
+			case "json":
+				b, err := json.MarshalIndent(shown, "", "  ")
 				if err != nil {
-					return err
+					fatal(err)
+				}
+				fmt.Println(string(b))
+			case "table":
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+				for _, s := range shown {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
 				}
+				w.Flush()
+			default:
+				fatal(fmt.Errorf("Invalid output format: %s", configShowFormat))
+			}
+		},
+	}
+	configShowCommand.Flags().StringVarP(&configShowFormat, "output-format", "o", "table", "Output format (table or json)")
+
+	configGetCommand := &cobra.Command{
+		Use:         "get [KEY]",
+		Short:       "Print a setting from the config file",
+		Long:        "Print a setting from the config file. With --profile, the setting is read from that profile's section.",
+		Args:        cobra.ExactArgs(1),
+		Annotations: requires(requiresConfig),
+		Run: func(cmd *cobra.Command, args []string) {
+			setting, ok := plaid_cli.LookupSetting(args[0])
+			if !ok {
+				fatal(unknownSettingError(args[0]))
+			}
+
+			file, err := plaid_cli.ReadConfigFile(configPath)
+			if err != nil {
+				fatal(plaid_cli.NewConfigError("Error reading config file: %s", err))
+			}
+
+			key := profileKey(setting.Key)
+			if !file.IsSet(key) {
+				fatal(plaid_cli.NewConfigError("%s isn't set in %s", key, configPath))
+			}
+
+			value := file.Get(key)
+			if setting.Kind == plaid_cli.KindList {
+				value = file.GetStringSlice(key)
+			}
+
+			// Print the actual value, even for secrets: it was asked for
+			// explicitly.
+			fmt.Println(plaid_cli.Setting{Kind: setting.Kind}.Format(value))
+		},
+	}
+
+	configSetCommand := &cobra.Command{
+		Use:         "set [KEY] [VALUE]",
+		Short:       "Set a setting in the config file",
+		Long:        "Set a setting in the config file. With --profile, the setting is written to that profile's section, which is created if needed. Lists are comma separated.",
+		Args:        cobra.ExactArgs(2),
+		Annotations: map[string]string{requiresAnnotation: requiresConfig, newProfileAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			setting, ok := plaid_cli.LookupSetting(args[0])
+			if !ok {
+				fatal(unknownSettingError(args[0]))
+			}
+
+			value, err := setting.Parse(args[1])
+			if err != nil {
+				fatal(plaid_cli.NewConfigError("Invalid value for %s: %s", setting.Key, err))
+			}
+
+			if err := validateSetting(setting.Key, value); err != nil {
+				fatal(err)
+			}
+
+			key := profileKey(setting.Key)
+			err = plaid_cli.UpdateConfigFile(configPath, map[string]interface{}{key: value})
+			if err != nil {
+				fatal(err)
+			}
+
+			log.Println(fmt.Sprintf("Set %s in %s.", key, configPath))
+		},
+	}
+
+	configCommand.AddCommand(configShowCommand)
+	configCommand.AddCommand(configGetCommand)
+	configCommand.AddCommand(configSetCommand)
+
+	completionCommand := &cobra.Command{
+		Use:       "completion [bash|zsh|fish|powershell]",
+		Short:     "Generate shell completion scripts",
+		Long:      "Generate shell completion scripts. For example, add `source <(plaid-cli completion bash)` to your ~/.bashrc.",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			switch args[0] {
+			case "bash":
+				err = cmd.Root().GenBashCompletion(os.Stdout)
+			case "zsh":
+				err = cmd.Root().GenZshCompletion(os.Stdout)
+			case "fish":
+				err = cmd.Root().GenFishCompletion(os.Stdout, true)
+			case "powershell":
+				err = cmd.Root().GenPowerShellCompletion(os.Stdout)
+			}
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	rootCommand := &cobra.Command{
+		Use:   "plaid-cli",
+		Short: "Link bank accounts and get transactions from the command line.",
+		Long: `plaid-cli 🤑
+
+plaid-cli is a CLI tool for working with the Plaid API.
+
+You can use plaid-cli to link bank accounts and pull transactions in multiple 
+output formats from the comfort of the command line.
+
+Configuration:
+  To get started, you'll need Plaid API credentials, which you can get by visiting
+  https://dashboard.plaid.com/team/keys after signing up for free.
+  
+  plaid-cli will look at the following environment variables for API credentials:
+  
+    PLAID_CLIENT_ID=<client id>
+    PLAID_SECRET=<devlopment secret>
+    PLAID_ENVIRONMENT=development
+    PLAID_LANGUAGE=en  # optional, detected using system's locale
+    PLAID_COUNTRIES=US # optional, detected using system's locale
+  
+  I recommend setting and exporting these on shell startup.
+  
+  API credentials can also be specified using a config file located at 
+  ~/.plaid-cli/config.toml:
+  
+    [plaid]
+    client_id = "<client id>"
+    secret = "<development secret>"
+    environment = "development"
+  
+  After setting those API credentials, plaid-cli is ready to use! 
+  You'll probably want to run 'plaid-cli link' next.
+  
+  Please see the README (https://github.com/landakram/plaid-cli/blob/master/README.md) 
+  for more detailed usage instructions.
+
+Exit status:
+  0  success
+  1  other error
+  2  configuration error
+  3  an institution needs to be relinked or otherwise fixed (see --no-relink)
+  4  rate limited by Plaid
+  5  network error
+  6  aborted by the user
+  7  something needs attention, like a budget over its alert threshold or
+     unusual charges
+
+  Made by @landakram.
+`,
+	}
+	rootCommand.PersistentPreRunE = setup
+	rootCommand.SilenceErrors = true
+	rootCommand.PersistentFlags().String("profile", plaid_cli.DefaultProfile, "Configuration profile to use, with its own credentials and linked institutions")
+	viper.BindPFlag("cli.profile", rootCommand.PersistentFlags().Lookup("profile"))
+	rootCommand.PersistentFlags().Bool("no-relink", false, "Fail instead of prompting when an institution needs to be relinked, or waiting when it's temporarily unavailable")
+	viper.BindPFlag("cli.no_relink", rootCommand.PersistentFlags().Lookup("no-relink"))
+	rootCommand.PersistentFlags().String("error-format", "text", "Format of error messages (text or json)")
+	viper.BindPFlag("cli.error_format", rootCommand.PersistentFlags().Lookup("error-format"))
+
+	rootCommand.AddCommand(linkCommand)
+	rootCommand.AddCommand(tokensCommand)
+	rootCommand.AddCommand(aliasCommand)
+	rootCommand.AddCommand(aliasesCommand)
+	rootCommand.AddCommand(accountsCommand)
+	rootCommand.AddCommand(transactionsCommand)
+	rootCommand.AddCommand(summaryCommand)
+	rootCommand.AddCommand(rulesCommand)
+	rootCommand.AddCommand(recurringCommand)
+	rootCommand.AddCommand(budgetCommand)
+	rootCommand.AddCommand(reportCommand)
+	rootCommand.AddCommand(anomaliesCommand)
+	rootCommand.AddCommand(watchCommand)
+	rootCommand.AddCommand(webhookCommand)
+	rootCommand.AddCommand(itemCommand)
+	rootCommand.AddCommand(serveCommand)
+	rootCommand.AddCommand(tagCommand)
+	rootCommand.AddCommand(noteCommand)
+	rootCommand.AddCommand(insitutionCommand)
+	rootCommand.AddCommand(doctorCommand)
+	rootCommand.AddCommand(initCommand)
+	rootCommand.AddCommand(configCommand)
+	rootCommand.AddCommand(completionCommand)
+
+	// Cobra reports some errors, like unknown flags, before setup runs, so
+	// the error format is read from the environment and arguments up front.
+	configureEnv()
+	if format, ok := errorFormatArg(os.Args[1:]); ok {
+		viper.Set("cli.error_format", format)
+	}
+	if viper.GetString("cli.error_format") == "json" {
+		rootCommand.SilenceUsage = true
+	}
+
+	if err := rootCommand.Execute(); err != nil {
+		fatal(err)
+	}
+}
+
+// errorFormatArg finds --error-format in the command line arguments.
+func errorFormatArg(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--error-format=") {
+			return strings.TrimPrefix(arg, "--error-format="), true
+		}
+		if arg == "--error-format" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+
+	return "", false
+}
+
+// newTransactionFetcher returns a fetcher that shows progress when stderr
+// is a terminal.
+func newTransactionFetcher(opts plaid.ClientOptions) *plaid_cli.TransactionFetcher {
+	fetcher := plaid_cli.NewTransactionFetcher(opts)
+	if isTerminal(os.Stderr) {
+		fetcher.Progress = os.Stderr
+	}
+
+	return fetcher
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// interruptContext returns a context that is canceled on Ctrl-C, until stop
+// is called.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// prepareTransactions applies rules, then local annotations, marks
+// transfers, pairing them with counterparts if there are any, converts
+// amounts if converter is set, then applies the filter.
+func prepareTransactions(txs []plaid_cli.Transaction, counterparts []plaid_cli.Transaction, converter *plaid_cli.Converter, rules plaid_cli.Rules, data *plaid_cli.Data, filter *plaid_cli.TransactionFilter) ([]plaid_cli.Transaction, error) {
+	txs = data.Annotate(rules.Apply(txs))
+	if counterparts != nil {
+		plaid_cli.PairTransfers(txs, counterparts, viper.GetInt("transfers.window_days"))
+	}
+	plaid_cli.MarkTransfers(txs)
+
+	if err := converter.Convert(txs); err != nil {
+		return nil, err
+	}
+
+	return filter.Apply(txs), nil
+}
+
+// newEventEmitter returns a function that writes sync events to stdout as
+// NDJSON or, if hook is set, runs it with each event on stdin.
+func newEventEmitter(hook string) func(plaid_cli.SyncEvent) error {
+	encoder := json.NewEncoder(os.Stdout)
+
+	return func(event plaid_cli.SyncEvent) error {
+		if hook == "" {
+			return encoder.Encode(event)
+		}
+
+		input, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return plaid_cli.RunHook(hook, input, viper.GetDuration("cli.hook_timeout"))
+	}
+}
+
+// apiItem is a linked institution as listed by serve.
+type apiItem struct {
+	ItemID      string     `json:"item_id"`
+	Alias       string     `json:"alias,omitempty"`
+	Environment string     `json:"environment,omitempty"`
+	LinkedAt    *time.Time `json:"linked_at,omitempty"`
+}
+
+// setFlagsFromQuery sets cmd's flags from the URL query parameters of the
+// same name, so API requests take the same options as commands. A boolean
+// parameter without a value is true.
+func setFlagsFromQuery(cmd *cobra.Command, query url.Values) error {
+	for name, values := range query {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("Unknown parameter %s", name)
+		}
+
+		for _, value := range values {
+			if value == "" && flag.Value.Type() == "bool" {
+				value = "true"
+			}
+			if err := cmd.Flags().Set(name, value); err != nil {
+				return fmt.Errorf("Invalid %s: %s", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// addConvertFlag adds the flag read by converterFromFlags.
+func addConvertFlag(cmd *cobra.Command) {
+	cmd.Flags().String("convert-to", "", "Convert amounts to this currency, like USD, using the exchange rates file")
+}
+
+// converterFromFlags loads exchange rates for --convert-to from the rates
+// file in dataDir, or the configured one. It returns nil if the flag isn't
+// set.
+func converterFromFlags(cmd *cobra.Command, dataDir string) (*plaid_cli.Converter, error) {
+	to, _ := cmd.Flags().GetString("convert-to")
+	if to == "" {
+		return nil, nil
+	}
+
+	path := plaid_cli.RatesPath(dataDir, viper.GetString("currency.rates_file"))
+	if path == "" {
+		return nil, plaid_cli.NewConfigError("--convert-to needs exchange rates. Put them in rates.csv or rates.json in %s, or set currency.rates_file.", dataDir)
+	}
+
+	rates, err := plaid_cli.ReadRatesFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plaid_cli.Converter{To: strings.ToUpper(to), Rates: rates}, nil
+}
+
+// addTransactionFilterFlags adds the flags read by transactionFilterFromFlags.
+func addTransactionFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("pending", false, "Only show pending transactions")
+	cmd.Flags().Bool("posted", false, "Only show posted transactions")
+	cmd.Flags().Float64("min", 0, "Only show transactions with at least this amount (money out is positive)")
+	cmd.Flags().Float64("max", 0, "Only show transactions with at most this amount (money out is positive)")
+	cmd.Flags().StringSlice("category", nil, "Only show transactions in this category or its subcategories, e.g. \"Food and Drink > Restaurants\" (repeatable)")
+	cmd.Flags().StringSlice("merchant", nil, "Only show transactions from this merchant (repeatable)")
+	cmd.Flags().StringSlice("tag", nil, "Only show transactions with this tag (repeatable)")
+	cmd.Flags().Bool("reimbursable", false, "Only show transactions marked reimbursable")
+	cmd.Flags().String("search", "", "Only show transactions whose name, merchant or note matches this case-insensitive regular expression")
+	cmd.Flags().Bool("exclude-transfers", false, "Hide transfers between accounts and credit card payments")
+	cmd.Flags().Bool("detect-transfers", false, "Find transfers to and from your other linked institutions by pairing matching transactions")
+}
+
+func transactionFilterFromFlags(cmd *cobra.Command) (*plaid_cli.TransactionFilter, error) {
+	flags := cmd.Flags()
+	filter := &plaid_cli.TransactionFilter{}
+
+	pending, _ := flags.GetBool("pending")
+	posted, _ := flags.GetBool("posted")
+	if pending && posted {
+		return nil, errors.New("--pending and --posted can't be used together")
+	}
+	if pending || posted {
+		filter.Pending = &pending
+	}
+
+	if flags.Changed("min") {
+		min, _ := flags.GetFloat64("min")
+		filter.Min = &min
+	}
+	if flags.Changed("max") {
+		max, _ := flags.GetFloat64("max")
+		filter.Max = &max
+	}
+
+	filter.Categories, _ = flags.GetStringSlice("category")
+	filter.Merchants, _ = flags.GetStringSlice("merchant")
+	filter.Tags, _ = flags.GetStringSlice("tag")
+	filter.Reimbursable, _ = flags.GetBool("reimbursable")
+	filter.ExcludeTransfers, _ = flags.GetBool("exclude-transfers")
+
+	if search, _ := flags.GetString("search"); search != "" {
+		re, err := regexp.Compile("(?i)" + search)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --search: %s", err)
+		}
+		filter.Search = re
+	}
+
+	return filter, nil
+}
+
+// dataMu is held while data files are written, and read by commands that
+// handle requests concurrently.
+var dataMu sync.Mutex
+
+// WithItemErrorHandling runs action and tries to remediate item errors it
+// returns, like relinking an item whose login expired.
+func WithItemErrorHandling(itemID string, data *plaid_cli.Data, linker *plaid_cli.Linker, env plaid_cli.ItemEnvironment, action func() error) error {
+	if err := data.CheckItemEnvironment(itemID, env); err != nil {
+		return err
+	}
+
+	err := action()
+	if err == nil {
+		// The token works, so it belongs to the current environment. Tag
+		// items that were linked before plaid-cli recorded environments.
+		if _, ok := data.Items[itemID]; !ok {
+			dataMu.Lock()
+			defer dataMu.Unlock()
+			return data.TagItem(itemID, env)
+		}
+		return nil
+	}
+
+	itemErr := plaid_cli.ClassifyError(itemID, err)
+	if itemErr == nil {
+		return err
+	}
+	if itemErr.Remediation == plaid_cli.RemediationNone {
+		return itemErr
+	}
+
+	// Jobs that can't prompt shouldn't block for long either. Temporary
+	// errors were already retried by the transport.
+	if viper.GetBool("cli.no_relink") && (itemErr.Remediation.Interactive() || itemErr.Remediation == plaid_cli.RemediationWaitAndRetry) {
+		return itemErr
+	}
+
+	if itemErr.Remediation == plaid_cli.RemediationRelink || itemErr.Remediation == plaid_cli.RemediationRelinkAccountSelection {
+		if err := validateLocale(linker.Countries(), linker.Language()); err != nil {
+			return err
+		}
+	}
+
+	port := viper.GetString("link.port")
+
+	switch itemErr.Remediation {
+	case plaid_cli.RemediationRelink:
+		log.Println(itemErr.Explanation, "Relinking...")
+		err = linker.Relink(itemID, port)
+	case plaid_cli.RemediationRelinkAccountSelection:
+		log.Println(itemErr.Explanation, "Relinking so you can select accounts...")
+		err = linker.RelinkWithAccountSelection(itemID, port)
+	case plaid_cli.RemediationRemoveToken:
+		log.Println(itemErr.Explanation)
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Remove the stored access token for %s", itemID),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return itemErr
+		}
+		if err := data.RemoveItem(itemID); err != nil {
+			return err
+		}
+		return fmt.Errorf("Removed the access token for %s. Run `plaid-cli link` to link the institution again.", itemID)
+	case plaid_cli.RemediationWaitAndRetry:
+		wait := viper.GetDuration("plaid.retry.item_wait")
+		log.Printf("%s Waiting %s before trying again...", itemErr.Explanation, wait)
+
+		ctx, stop := interruptContext()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-timer.C:
+		}
+		timer.Stop()
+		stop()
+	default:
+		return itemErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("Re-running action...")
+
+	err = action()
+	if retryErr := plaid_cli.ClassifyError(itemID, err); retryErr != nil {
+		return retryErr
+	}
+
+	return err
+}
+
+// fatal prints err in the format chosen with --error-format and exits with
+// a status that reflects its cause.
+func fatal(err error) {
+	report := plaid_cli.NewErrorReport(err)
+
+	if viper.GetString("cli.error_format") == "json" {
+		b, _ := json.Marshal(report)
+		fmt.Fprintln(os.Stderr, string(b))
+	} else {
+		log.Println(err)
+	}
+
+	os.Exit(report.ExitCode)
+}
+
+// TransactionSerializer writes transactions as they're fetched, one page at
+// a time.
+type TransactionSerializer interface {
+	// write writes a page of transactions.
+	write(txs []plaid_cli.Transaction) error
+	// close finishes the output after the last page.
+	close() error
+}
+
+func NewTransactionSerializer(t string, w io.Writer) (TransactionSerializer, error) {
+	switch t {
+	case "csv":
+		return &CSVSerializer{writer: csv.NewWriter(w)}, nil
+	case "json":
+		return &JSONSerializer{w: w}, nil
+	case "ndjson":
+		return &NDJSONSerializer{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid output format: %s", t))
+	}
+}
+
+type CSVSerializer struct {
+	writer      *csv.Writer
+	wroteHeader bool
+}
+
+func (s *CSVSerializer) writeHeader() error {
+	if s.wroteHeader {
+		return nil
+	}
+	s.wroteHeader = true
+
+	// Importers read columns by position, so new ones go at the end.
+	return s.writer.Write([]string{"Date", "Amount", "Description", "Category", "Tags", "Note", "Reimbursable", "Transfer", "Currency", "Original Amount", "Original Currency"})
+}
+
+func (s *CSVSerializer) write(txs []plaid_cli.Transaction) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+
+	for _, tx := range txs {
+		description := tx.Name
+		if tx.Payee != "" {
+			description = tx.Payee
+		}
 
-				b, err := json.MarshalIndent(resp.Institution, "", "  ")
-				if err != nil {
-					return err
-				}
+		var originalAmount string
+		if tx.OriginalCurrency != "" {
+			originalAmount = fmt.Sprintf("%f", tx.OriginalAmount)
+		}
 
-				fmt.Println(string(b))
+		sanitizedName := strings.ReplaceAll(description, ",", "")
+		record := []string{
+			tx.Date,
+			fmt.Sprintf("%f", tx.Amount),
+			sanitizedName,
+			strings.Join(tx.Category, " > "),
+			strings.Join(tx.Tags, " "),
+			tx.Note,
+			strconv.FormatBool(tx.Reimbursable),
+			strconv.FormatBool(plaid_cli.IsTransfer(tx)),
+			plaid_cli.Currency(tx),
+			originalAmount,
+			tx.OriginalCurrency,
+		}
+		if err := s.writer.Write(record); err != nil {
+			return err
+		}
+	}
 
-				return nil
-			})
+	s.writer.Flush()
+	return s.writer.Error()
+}
 
-			if err != nil {
-				log.Fatalln(err)
-			}
-		},
+func (s *CSVSerializer) close() error {
+	if err := s.writeHeader(); err != nil {
+		return err
 	}
-	insitutionCommand.Flags().BoolVarP(&withStatusFlag, "status", "s", false, "Fetch institution status")
-	insitutionCommand.Flags().BoolVarP(&withOptionalMetadataFlag, "optional-metadata", "m", false, "Fetch optional metadata like logo and URL")
 
-	rootCommand := &cobra.Command{
-		Use:   "plaid-cli",
-		Short: "Link bank accounts and get transactions from the command line.",
-		Long: `plaid-cli 🤑
+	s.writer.Flush()
+	return s.writer.Error()
+}
 
-plaid-cli is a CLI tool for working with the Plaid API.
+// settingSource describes where the effective value of a setting came from,
+// in viper's order of precedence.
+func settingSource(cmd *cobra.Command, setting plaid_cli.Setting, file *viper.Viper, configPath string, localeDefaults map[string]bool) string {
+	if setting.Flag != "" {
+		if flag := cmd.Flags().Lookup(setting.Flag); flag != nil && flag.Changed {
+			return "flag --" + setting.Flag
+		}
+	}
 
-You can use plaid-cli to link bank accounts and pull transactions in multiple 
-output formats from the comfort of the command line.
+	envName := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(setting.Key))
+	if v, ok := os.LookupEnv(envName); ok && v != "" {
+		return "env " + envName
+	}
 
-Configuration:
-  To get started, you'll need Plaid API credentials, which you can get by visiting
-  https://dashboard.plaid.com/team/keys after signing up for free.
-  
-  plaid-cli will look at the following environment variables for API credentials:
-  
-    PLAID_CLIENT_ID=<client id>
-    PLAID_SECRET=<devlopment secret>
-    PLAID_ENVIRONMENT=development
-    PLAID_LANGUAGE=en  # optional, detected using system's locale
-    PLAID_COUNTRIES=US # optional, detected using system's locale
-  
-  I recommend setting and exporting these on shell startup.
-  
-  API credentials can also be specified using a config file located at 
-  ~/.plaid-cli/config.toml:
-  
-    [plaid]
-    client_id = "<client id>"
-    secret = "<development secret>"
-    environment = "development"
-  
-  After setting those API credentials, plaid-cli is ready to use! 
-  You'll probably want to run 'plaid-cli link' next.
-  
-  Please see the README (https://github.com/landakram/plaid-cli/blob/master/README.md) 
-  for more detailed usage instructions.
+	if profile := viper.GetString("cli.profile"); profile != plaid_cli.DefaultProfile {
+		if file.IsSet("profiles." + profile + "." + setting.Key) {
+			return fmt.Sprintf("file %s [profiles.%s]", configPath, profile)
+		}
+	}
 
-  Made by @landakram.
-`,
+	if file.IsSet(setting.Key) {
+		return "file " + configPath
+	}
+
+	if localeDefaults[setting.Key] {
+		return "locale"
+	}
+
+	// Flag defaults count as defaults, unless they're empty.
+	if setting.Format(viper.Get(setting.Key)) == "" {
+		return "unset"
 	}
-	rootCommand.AddCommand(linkCommand)
-	rootCommand.AddCommand(tokensCommand)
-	rootCommand.AddCommand(aliasCommand)
-	rootCommand.AddCommand(aliasesCommand)
-	rootCommand.AddCommand(accountsCommand)
-	rootCommand.AddCommand(transactionsCommand)
-	rootCommand.AddCommand(insitutionCommand)
 
-	if !viper.IsSet("plaid.client_id") {
-		log.Println("⚠️  PLAID_CLIENT_ID not set. Please see the configuration instructions below.")
-		rootCommand.Help()
-		os.Exit(1)
+	return "default"
+}
+
+// profileKey returns where a key lives in the config file for the current
+// profile.
+func profileKey(key string) string {
+	if profile := viper.GetString("cli.profile"); profile != plaid_cli.DefaultProfile {
+		return "profiles." + profile + "." + key
+	}
+
+	return key
+}
+
+func unknownSettingError(key string) error {
+	var keys []string
+	for _, s := range plaid_cli.Settings {
+		keys = append(keys, s.Key)
 	}
-	if !viper.IsSet("plaid.secret") {
-		log.Println("⚠️ PLAID_SECRET not set. Please see the configuration instructions below.")
-		rootCommand.Help()
-		os.Exit(1)
+
+	return plaid_cli.NewConfigError("Unknown setting `%s`. Known settings: %s", key, strings.Join(keys, ", "))
+}
+
+// validateSetting checks values that plaid-cli would otherwise reject at
+// startup.
+func validateSetting(key string, value interface{}) error {
+	switch key {
+	case "plaid.environment":
+		env := strings.ToLower(value.(string))
+		if env != "development" && env != "production" {
+			return plaid_cli.NewConfigError("Invalid plaid environment. Valid plaid environments are 'development' or 'production'.")
+		}
+	case "plaid.countries":
+		var countries []string
+		for _, c := range value.([]string) {
+			countries = append(countries, strings.ToUpper(c))
+		}
+		if !AreValidCountries(countries) {
+			return plaid_cli.NewConfigError("Invalid countries. Plaid supports the following countries: %v", plaidSupportedCountries)
+		}
+	case "plaid.language":
+		if !IsValidLanguageCode(value.(string)) {
+			return plaid_cli.NewConfigError("Invalid language code. Plaid supports the following languages: %v", plaidSupportedLanguages)
+		}
+	case "cli.profile":
+		if !plaid_cli.IsValidProfileName(value.(string)) {
+			return plaid_cli.NewConfigError("Invalid profile name. Valid characters: [0-9A-Za-z_-]")
+		}
 	}
 
-	rootCommand.Execute()
+	return nil
 }
 
-func AllTransactions(opts plaid.GetTransactionsOptions, client *plaid.Client, token string) ([]plaid.Transaction, error) {
-	var transactions []plaid.Transaction
+func runInit(dataDir string, profile string) error {
+	configPath := filepath.Join(dataDir, "config.toml")
+
+	if _, err := os.Stat(configPath); err == nil {
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("%s already exists. Update the %s profile in it", configPath, profile),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return plaid_cli.ErrAborted
+		}
+	}
+
+	log.Println("You can find your API keys at https://dashboard.plaid.com/team/keys.")
 
-	res, err := client.GetTransactionsWithOptions(token, opts)
+	notEmpty := func(input string) error {
+		if strings.TrimSpace(input) == "" {
+			return errors.New("Required")
+		}
+		return nil
+	}
+
+	clientID, err := (&promptui.Prompt{Label: "Client ID", Validate: notEmpty}).Run()
 	if err != nil {
-		return transactions, err
+		return err
 	}
 
-	transactions = append(transactions, res.Transactions...)
+	secret, err := (&promptui.Prompt{Label: "Secret", Mask: '*', Validate: notEmpty}).Run()
+	if err != nil {
+		return err
+	}
 
-	for len(transactions) < res.TotalTransactions {
-		opts.Offset += opts.Count
-		res, err := client.GetTransactionsWithOptions(token, opts)
-		if err != nil {
-			return transactions, err
+	environments := []string{"development", "production"}
+	envSelect := promptui.Select{
+		Label: "Environment",
+		Items: environments,
+	}
+	_, environment, err := envSelect.Run()
+	if err != nil {
+		return err
+	}
+
+	detectedCountry, detectedLang := detectLocale()
+
+	parseCountries := func(input string) []string {
+		var countries []string
+		for _, c := range strings.Split(input, ",") {
+			if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+				countries = append(countries, c)
+			}
+		}
+		return countries
+	}
+
+	countriesInput, err := (&promptui.Prompt{
+		Label:   "Countries (comma separated)",
+		Default: detectedCountry,
+		Validate: func(input string) error {
+			countries := parseCountries(input)
+			if len(countries) == 0 || !AreValidCountries(countries) {
+				return fmt.Errorf("Supported countries: %s", strings.Join(plaidSupportedCountries, ", "))
+			}
+			return nil
+		},
+	}).Run()
+	if err != nil {
+		return err
+	}
+	countries := parseCountries(countriesInput)
+
+	lang, err := (&promptui.Prompt{
+		Label:   "Language",
+		Default: detectedLang,
+		Validate: func(input string) error {
+			if !IsValidLanguageCode(input) {
+				return fmt.Errorf("Supported languages: %s", strings.Join(plaidSupportedLanguages, ", "))
+			}
+			return nil
+		},
+	}).Run()
+	if err != nil {
+		return err
+	}
+
+	plaidEnv := plaid.Development
+	if environment == "production" {
+		plaidEnv = plaid.Production
+	}
+
+	client, err := plaid.NewClient(plaid.ClientOptions{
+		ClientID:    clientID,
+		Secret:      secret,
+		Environment: plaidEnv,
+		HTTPClient:  plaid_cli.NewRetryClient(plaid_cli.DefaultRetryPolicy()),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Println("Checking credentials...")
+	// Listing a single institution is the cheapest call that needs valid keys.
+	if _, err := client.GetInstitutions(1, 0, countries); err != nil {
+		log.Println(err)
+		prompt := promptui.Prompt{
+			Label:     "The credentials couldn't be verified. Save them anyway",
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return plaid_cli.ErrAborted
 		}
+	}
 
-		transactions = append(transactions, res.Transactions...)
+	prefix := ""
+	if profile != plaid_cli.DefaultProfile {
+		prefix = "profiles." + profile + "."
+	}
 
+	err = plaid_cli.UpdateConfigFile(configPath, map[string]interface{}{
+		prefix + "plaid.client_id":   clientID,
+		prefix + "plaid.secret":      secret,
+		prefix + "plaid.environment": environment,
+		prefix + "plaid.countries":   countries,
+		prefix + "plaid.language":    lang,
+	})
+	if err != nil {
+		return err
 	}
 
-	return transactions, nil
+	log.Println(fmt.Sprintf("Saved config to %s.", configPath))
+	log.Println("You'll probably want to run `plaid-cli link` next.")
+
+	return nil
 }
 
-func WithRelinkOnAuthError(itemID string, data *plaid_cli.Data, linker *plaid_cli.Linker, action func() error) error {
-	err := action()
-	if e, ok := err.(plaid.Error); ok {
-		if e.ErrorCode == "ITEM_LOGIN_REQUIRED" {
-			log.Println("Login expired. Relinking...")
+func checkConfig(client *plaid.Client, clientErr error, countries []string, lang string) []plaid_cli.Check {
+	ok := func(name, msg string) plaid_cli.Check {
+		return plaid_cli.Check{Name: name, Severity: plaid_cli.SeverityOK, Message: msg}
+	}
+	failed := func(name, msg string) plaid_cli.Check {
+		return plaid_cli.Check{Name: name, Severity: plaid_cli.SeverityError, Message: msg}
+	}
 
-			port := viper.GetString("link.port")
+	var checks []plaid_cli.Check
 
-			err = linker.Relink(itemID, port)
+	if file := viper.ConfigFileUsed(); file != "" {
+		checks = append(checks, ok("config file", file))
+	} else {
+		checks = append(checks, ok("config file", "none, using environment variables"))
+	}
 
-			if err != nil {
-				return err
-			}
+	switch env := viper.GetString("plaid.environment"); strings.ToLower(env) {
+	case "development", "production":
+		checks = append(checks, ok("environment", env))
+	default:
+		checks = append(checks, failed("environment", fmt.Sprintf("%s isn't development or production", env)))
+	}
+
+	if AreValidCountries(countries) {
+		checks = append(checks, ok("countries", strings.Join(countries, ",")))
+	} else {
+		checks = append(checks, failed("countries", fmt.Sprintf("%v includes a country Plaid doesn't support", countries)))
+	}
+
+	if IsValidLanguageCode(lang) {
+		checks = append(checks, ok("language", lang))
+	} else {
+		checks = append(checks, failed("language", fmt.Sprintf("%s isn't supported by Plaid", lang)))
+	}
+
+	// Listing a single institution is the cheapest call that needs valid keys.
+	if clientErr != nil {
+		checks = append(checks, failed("credentials", clientErr.Error()))
+	} else if _, err := client.GetInstitutions(1, 0, countries); err != nil {
+		checks = append(checks, failed("credentials", err.Error()))
+	} else {
+		checks = append(checks, ok("credentials", "valid"))
+	}
 
-			log.Println("Re-running action...")
+	return checks
+}
+
+func printDoctorReport(out io.Writer, report *plaid_cli.DoctorReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
 
-			err = action()
+	fmt.Fprintln(w, "CHECK\tSTATUS\tDETAILS")
+	for _, checks := range [][]plaid_cli.Check{report.Config, report.Data} {
+		for _, c := range checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Severity, c.Message)
 		}
 	}
+	fmt.Fprintln(w)
 
-	return err
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	}
+
+	fmt.Fprintln(w, "ITEM\tINSTITUTION\tSTATUS\tERROR\tCONSENT EXPIRES\tLAST UPDATE\tINSTITUTION STATUS")
+	for _, item := range report.Items {
+		name := item.ItemID
+		if item.Alias != "" {
+			name = item.Alias
+		}
+
+		errorCode := item.ErrorCode
+		if errorCode == "" {
+			errorCode = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name,
+			item.InstitutionName,
+			item.Severity,
+			errorCode,
+			formatTime(item.ConsentExpiration),
+			formatTime(item.LastTransactionsUpdate),
+			item.InstitutionStatus,
+		)
+	}
+	w.Flush()
+
+	for _, item := range report.Items {
+		for _, problem := range item.Problems {
+			fmt.Fprintf(out, "⚠️  %s: %s\n", item.ItemID, problem)
+		}
+	}
 }
 
-type TransactionSerializer interface {
-	serialize(txs []plaid.Transaction) ([]byte, error)
+// resolveItems returns the item IDs for the given items or aliases, or every
+// linked item if none are given.
+func resolveItems(data *plaid_cli.Data, itemsOrAliases []string) []string {
+	var itemIDs []string
+
+	if len(itemsOrAliases) == 0 {
+		for itemID := range data.Tokens {
+			itemIDs = append(itemIDs, itemID)
+		}
+		sort.Strings(itemIDs)
+		return itemIDs
+	}
+
+	for _, itemOrAlias := range itemsOrAliases {
+		if itemID, ok := data.Aliases[itemOrAlias]; ok {
+			itemOrAlias = itemID
+		}
+		itemIDs = append(itemIDs, itemOrAlias)
+	}
+
+	return itemIDs
 }
 
-func NewTransactionSerializer(t string) (TransactionSerializer, error) {
-	switch t {
-	case "csv":
-		return &CSVSerializer{}, nil
-	case "json":
-		return &JSONSerializer{}, nil
-	default:
-		return nil, errors.New(fmt.Sprintf("Invalid output format: %s", t))
+func printRecurring(out io.Writer, data *plaid_cli.Data, streams []*plaid_cli.RecurringStream) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "STATUS\tMERCHANT\tCADENCE\tCOUNT\tCURRENCY\tAVERAGE\tLAST\tNEXT\tPRICE CHANGE\tITEM")
+	for _, s := range streams {
+		item := s.ItemID
+		if alias, ok := data.BackAliases[s.ItemID]; ok {
+			item = alias
+		}
+
+		priceChange := "-"
+		if n := len(s.PriceChanges); n > 0 {
+			c := s.PriceChanges[n-1]
+			priceChange = fmt.Sprintf("%.2f → %.2f on %s", c.From, c.To, c.Date)
+		}
+
+		next := s.NextDate
+		if next == "" {
+			next = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%.2f\t%.2f (%s)\t%s\t%s\t%s\n",
+			s.Status,
+			s.Merchant,
+			s.Cadence,
+			s.Count,
+			s.Currency,
+			s.AverageAmount,
+			s.LastAmount,
+			s.LastDate,
+			next,
+			priceChange,
+			item,
+		)
+	}
+	w.Flush()
+}
+
+func printBudgets(out io.Writer, statuses []plaid_cli.BudgetStatus) {
+	const barWidth = 20
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUDGET\tAVAILABLE\tSPENT\tREMAINING\tPROGRESS\t\tPROJECTED\tSTATUS")
+	for _, s := range statuses {
+		filled := int(s.Percent / 100 * barWidth)
+		if filled > barWidth {
+			filled = barWidth
+		}
+		if filled < 0 {
+			filled = 0
+		}
+		bar := "[" + strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled) + "]"
+
+		available := fmt.Sprintf("%.2f", s.Available)
+		if s.Rollover != 0 {
+			available += fmt.Sprintf(" (%+.2f)", s.Rollover)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%s\t%.0f%%\t%.2f\t%s\n",
+			s.Name,
+			available,
+			s.Spent,
+			s.Remaining,
+			bar,
+			s.Percent,
+			s.Projected,
+			s.Status,
+		)
+	}
+	w.Flush()
+}
+
+// printAnomalies shows flagged transactions as a table.
+func printAnomalies(out io.Writer, data *plaid_cli.Data, anomalies []plaid_cli.Anomaly) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "DATE\tAMOUNT\tMERCHANT\tKIND\tREASON\tITEM")
+	for _, a := range anomalies {
+		item := a.ItemID
+		if alias, ok := data.BackAliases[a.ItemID]; ok {
+			item = alias
+		}
+
+		fmt.Fprintf(w, "%s\t%.2f %s\t%s\t%s\t%s\t%s\n",
+			a.Transaction.Date,
+			a.Transaction.Amount,
+			plaid_cli.Currency(a.Transaction),
+			plaid_cli.Merchant(a.Transaction),
+			a.Kind,
+			a.Reason,
+			item,
+		)
 	}
+	w.Flush()
 }
 
-type CSVSerializer struct{}
+// printRuleMatches shows which rule matches each transaction.
+func printRuleMatches(out io.Writer, rules plaid_cli.Rules, txs []plaid_cli.Transaction, format string) error {
+	type ruleMatch struct {
+		TransactionID string  `json:"transaction_id"`
+		Date          string  `json:"date"`
+		Amount        float64 `json:"amount"`
+		Name          string  `json:"name"`
+		Rule          string  `json:"rule,omitempty"`
+		Actions       string  `json:"actions,omitempty"`
+	}
 
-func (w *CSVSerializer) serialize(txs []plaid.Transaction) ([]byte, error) {
-	var records [][]string
+	matches := []ruleMatch{}
 	for _, tx := range txs {
-		sanitizedName := strings.ReplaceAll(tx.Name, ",", "")
-		records = append(records, []string{tx.Date, fmt.Sprintf("%f", tx.Amount), sanitizedName})
+		m := ruleMatch{
+			TransactionID: tx.ID,
+			Date:          tx.Date,
+			Amount:        tx.Amount,
+			Name:          tx.Name,
+		}
+		if rule := rules.Match(tx); rule != nil {
+			m.Rule = rule.Name
+			m.Actions = rule.Describe()
+		}
+		matches = append(matches, m)
 	}
 
-	b := bytes.NewBufferString("")
-	writer := csv.NewWriter(b)
-	err := writer.Write([]string{"Date", "Amount", "Description"})
-	if err != nil {
-		return nil, err
+	if format == "json" {
+		b, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(b))
+		return nil
 	}
-	err = writer.WriteAll(records)
-	if err != nil {
-		return nil, err
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tAMOUNT\tNAME\tRULE\tACTIONS")
+	for _, m := range matches {
+		rule, actions := m.Rule, m.Actions
+		if rule == "" {
+			rule, actions = "-", "-"
+		}
+		fmt.Fprintf(w, "%s\t%.2f\t%s\t%s\t%s\n", m.Date, m.Amount, m.Name, rule, actions)
 	}
+	return w.Flush()
+}
+
+// writeSummary writes a summary as a table, CSV or JSON.
+func writeSummary(out io.Writer, summary *plaid_cli.Summary, format string) error {
+	groups := append(append([]plaid_cli.Group{}, summary.Groups...), summary.Totals...)
 
-	return b.Bytes(), err
+	switch format {
+	case "table":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "%s\tCURRENCY\tCOUNT\tTOTAL\tAVERAGE\tINFLOW\tOUTFLOW\t\n", strings.ToUpper(summary.GroupBy))
+		for _, g := range groups {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t\n", g.Key, g.Currency, g.Count, g.Total, g.Average, g.Inflow, g.Outflow)
+		}
+		return w.Flush()
+	case "csv":
+		w := csv.NewWriter(out)
+		w.Write([]string{summary.GroupBy, "Count", "Total", "Average", "Inflow", "Outflow", "Currency"})
+		for _, g := range groups {
+			w.Write([]string{
+				g.Key,
+				fmt.Sprintf("%d", g.Count),
+				fmt.Sprintf("%.2f", g.Total),
+				fmt.Sprintf("%.2f", g.Average),
+				fmt.Sprintf("%.2f", g.Inflow),
+				fmt.Sprintf("%.2f", g.Outflow),
+				g.Currency,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	case "json":
+		b, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(b))
+		return nil
+	default:
+		return fmt.Errorf("Invalid output format %q. Choose table, csv or json.", format)
+	}
 }
 
 func SetAlias(data *plaid_cli.Data, itemID string, alias string) error {
@@ -592,8 +3499,61 @@ func SetAlias(data *plaid_cli.Data, itemID string, alias string) error {
 	return nil
 }
 
-type JSONSerializer struct{}
+// JSONSerializer writes an indented JSON array.
+type JSONSerializer struct {
+	w     io.Writer
+	count int
+}
+
+func (s *JSONSerializer) write(txs []plaid_cli.Transaction) error {
+	for _, tx := range txs {
+		b, err := json.MarshalIndent(tx, "  ", "  ")
+		if err != nil {
+			return err
+		}
+
+		sep := ",\n  "
+		if s.count == 0 {
+			sep = "[\n  "
+		}
+		s.count++
+
+		if _, err := io.WriteString(s.w, sep); err != nil {
+			return err
+		}
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONSerializer) close() error {
+	end := "\n]\n"
+	if s.count == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(s.w, end)
+	return err
+}
+
+// NDJSONSerializer writes one JSON object per line.
+type NDJSONSerializer struct {
+	encoder *json.Encoder
+}
+
+func (s *NDJSONSerializer) write(txs []plaid_cli.Transaction) error {
+	for _, tx := range txs {
+		if err := s.encoder.Encode(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-func (w *JSONSerializer) serialize(txs []plaid.Transaction) ([]byte, error) {
-	return json.MarshalIndent(txs, "", "  ")
+func (s *NDJSONSerializer) close() error {
+	return nil
 }
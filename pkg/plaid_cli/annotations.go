@@ -0,0 +1,41 @@
+package plaid_cli
+
+// Annotation is what the user noted about a transaction. It is kept locally,
+// keyed by Plaid transaction ID.
+type Annotation struct {
+	Tags         []string `json:"tags,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	Reimbursable bool     `json:"reimbursable,omitempty"`
+}
+
+func (a Annotation) empty() bool {
+	return len(a.Tags) == 0 && a.Note == "" && !a.Reimbursable
+}
+
+// SetAnnotation stores the annotation of a transaction, forgetting it if
+// it's empty.
+func (d *Data) SetAnnotation(transactionID string, a Annotation) error {
+	if a.empty() {
+		delete(d.Annotations, transactionID)
+	} else {
+		d.Annotations[transactionID] = a
+	}
+
+	return d.SaveAnnotations()
+}
+
+// Annotate adds the stored annotations to transactions in place.
+func (d *Data) Annotate(txs []Transaction) []Transaction {
+	for i := range txs {
+		a, ok := d.Annotations[txs[i].ID]
+		if !ok {
+			continue
+		}
+
+		txs[i].AddTags(a.Tags...)
+		txs[i].Note = a.Note
+		txs[i].Reimbursable = a.Reimbursable
+	}
+
+	return txs
+}
@@ -0,0 +1,208 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Anomaly kinds.
+const (
+	AnomalyLarge       = "large"
+	AnomalyNewMerchant = "new_merchant"
+	AnomalyDuplicate   = "duplicate"
+	AnomalyForeign     = "foreign"
+)
+
+// anomalyMinHistory is how many earlier charges a merchant or category needs
+// before an amount can be called unusual for it.
+const anomalyMinHistory = 3
+
+// AnomalyOptions tunes DetectAnomalies. Zero values disable a check.
+type AnomalyOptions struct {
+	// LargeFactor flags charges larger than this many times the usual
+	// charge at the merchant, or in the category if the merchant has too
+	// little history.
+	LargeFactor float64
+	// NewMerchantAmount flags charges of at least this amount at merchants
+	// never seen before.
+	NewMerchantAmount float64
+	// DuplicateDays flags charges of the same amount at the same merchant,
+	// on the same account, within this many days of each other.
+	DuplicateDays int
+	// Countries are the user's countries. Charges located elsewhere are
+	// flagged as foreign.
+	Countries []string
+}
+
+// Anomaly is a transaction that stands out, and why.
+type Anomaly struct {
+	Kind        string      `json:"kind"`
+	Reason      string      `json:"reason"`
+	ItemID      string      `json:"item_id,omitempty"`
+	Transaction Transaction `json:"transaction"`
+}
+
+// Key identifies the anomaly across runs.
+func (a Anomaly) Key() string {
+	return a.Kind + ":" + a.Transaction.ID
+}
+
+// DetectAnomalies checks txs against history, the transactions that came
+// before them. Only charges are checked: money coming in and transfers
+// between accounts are left alone.
+func DetectAnomalies(txs []Transaction, history []Transaction, opts AnomalyOptions) []Anomaly {
+	txs = append([]Transaction(nil), txs...)
+	sort.SliceStable(txs, func(i, j int) bool {
+		return txs[i].Date < txs[j].Date
+	})
+
+	// Usual amounts are per currency, so they can be compared.
+	byMerchant := map[string][]float64{}
+	byCategory := map[string][]float64{}
+	seen := map[string]bool{}
+	currencies := map[string]map[string]int{}
+	for _, tx := range append(append([]Transaction(nil), history...), txs...) {
+		if currencies[tx.AccountID] == nil {
+			currencies[tx.AccountID] = map[string]int{}
+		}
+		currencies[tx.AccountID][Currency(tx)]++
+	}
+	for _, tx := range history {
+		if !isCharge(tx) {
+			continue
+		}
+		merchant := normalizeMerchant(Merchant(tx))
+		merchantKey := merchant + " " + Currency(tx)
+		categoryKey := CategoryName(tx.Category) + " " + Currency(tx)
+		seen[merchant] = true
+		byMerchant[merchantKey] = append(byMerchant[merchantKey], tx.Amount)
+		byCategory[categoryKey] = append(byCategory[categoryKey], tx.Amount)
+	}
+
+	var anomalies []Anomaly
+	flag := func(tx Transaction, kind string, format string, args ...interface{}) {
+		anomalies = append(anomalies, Anomaly{Kind: kind, Reason: fmt.Sprintf(format, args...), Transaction: tx})
+	}
+
+	for i, tx := range txs {
+		if !isCharge(tx) {
+			continue
+		}
+
+		merchant := normalizeMerchant(Merchant(tx))
+		category := CategoryName(tx.Category)
+		merchantKey := merchant + " " + Currency(tx)
+		categoryKey := category + " " + Currency(tx)
+
+		if opts.LargeFactor > 0 {
+			if amounts := byMerchant[merchantKey]; len(amounts) >= anomalyMinHistory {
+				if usual := median(amounts); tx.Amount > usual*opts.LargeFactor {
+					flag(tx, AnomalyLarge, "%.2f is %.1fx the usual %.2f at %s", tx.Amount, tx.Amount/usual, usual, Merchant(tx))
+				}
+			} else if amounts := byCategory[categoryKey]; len(amounts) >= anomalyMinHistory {
+				if usual := median(amounts); tx.Amount > usual*opts.LargeFactor {
+					flag(tx, AnomalyLarge, "%.2f is %.1fx the usual %.2f in %s", tx.Amount, tx.Amount/usual, usual, category)
+				}
+			}
+		}
+
+		if opts.NewMerchantAmount > 0 && !seen[merchant] && tx.Amount >= opts.NewMerchantAmount {
+			flag(tx, AnomalyNewMerchant, "First charge from %s, for %.2f", Merchant(tx), tx.Amount)
+		}
+
+		if opts.DuplicateDays > 0 {
+			if original, ok := findDuplicate(tx, txs[:i], history, opts.DuplicateDays); ok {
+				flag(tx, AnomalyDuplicate, "Same amount as the charge from %s on %s", Merchant(original), original.Date)
+			}
+		}
+
+		if reason := foreignReason(tx, currencies[tx.AccountID], opts.Countries); reason != "" {
+			flag(tx, AnomalyForeign, "%s", reason)
+		}
+
+		// Later charges are compared with this one too.
+		seen[merchant] = true
+		byMerchant[merchantKey] = append(byMerchant[merchantKey], tx.Amount)
+		byCategory[categoryKey] = append(byCategory[categoryKey], tx.Amount)
+	}
+
+	return anomalies
+}
+
+func isCharge(tx Transaction) bool {
+	return tx.Amount > 0 && !IsTransfer(tx)
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// findDuplicate looks for an earlier charge that tx may duplicate. A posted
+// transaction isn't a duplicate of the pending one it replaced.
+func findDuplicate(tx Transaction, earlier []Transaction, history []Transaction, days int) (Transaction, bool) {
+	date, err := parseDay(tx.Date)
+	if err != nil {
+		return Transaction{}, false
+	}
+
+	for _, list := range [][]Transaction{earlier, history} {
+		for i := len(list) - 1; i >= 0; i-- {
+			other := list[i]
+			if other.ID == tx.ID || other.AccountID != tx.AccountID || !isCharge(other) {
+				continue
+			}
+			if other.ID == tx.PendingTransactionID || other.PendingTransactionID == tx.ID {
+				continue
+			}
+			if math.Abs(other.Amount-tx.Amount) >= 0.005 || normalizeMerchant(Merchant(other)) != normalizeMerchant(Merchant(tx)) {
+				continue
+			}
+
+			otherDate, err := parseDay(other.Date)
+			if err != nil {
+				continue
+			}
+			if math.Abs(date.Sub(otherDate).Hours())/24 <= float64(days) {
+				return other, true
+			}
+		}
+	}
+
+	return Transaction{}, false
+}
+
+// foreignReason explains why a charge looks foreign: it isn't in the
+// account's usual currency, or it happened outside the user's countries.
+func foreignReason(tx Transaction, currencies map[string]int, countries []string) string {
+	usual, count := "", 0
+	for c, n := range currencies {
+		if n > count || (n == count && c < usual) {
+			usual, count = c, n
+		}
+	}
+
+	if c := Currency(tx); c != "" && usual != "" && c != usual {
+		return fmt.Sprintf("Charged in %s instead of %s", c, usual)
+	}
+
+	country := tx.Location.Country
+	if country == "" || len(countries) == 0 {
+		return ""
+	}
+	for _, c := range countries {
+		if strings.EqualFold(c, country) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("Charged in %s", country)
+}
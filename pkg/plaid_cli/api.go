@@ -0,0 +1,133 @@
+package plaid_cli
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// BadRequestError is returned when an API request is invalid.
+type BadRequestError struct {
+	Err error
+}
+
+func NewBadRequestError(err error) *BadRequestError {
+	return &BadRequestError{Err: err}
+}
+
+func (e *BadRequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BadRequestError) Unwrap() error {
+	return e.Err
+}
+
+// NewAPIToken generates a random bearer token for the API server.
+func NewAPIToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// RequireBearerToken only lets requests through to next if they carry token
+// in an Authorization: Bearer header.
+func RequireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "Bearer "
+
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, prefix) || subtle.ConstantTimeCompare([]byte(header[len(prefix):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="plaid-cli"`)
+			WriteAPIError(w, NewBadRequestError(errors.New("Missing or invalid bearer token")), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriteJSON writes v as the JSON response body. It returns an error if the
+// response couldn't be sent in full.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		WriteAPIError(w, err, 0)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
+
+// WriteAPIError writes err as an ErrorReport, the same JSON that
+// --error-format json prints. A zero status is derived from the error.
+func WriteAPIError(w http.ResponseWriter, err error, status int) {
+	if status == 0 {
+		status = APIStatus(err)
+	}
+	if status >= http.StatusInternalServerError {
+		log.Println(err)
+	}
+
+	report := NewErrorReport(err)
+	var badRequest *BadRequestError
+	if errors.As(err, &badRequest) {
+		report.ErrorType = "INVALID_REQUEST"
+	}
+
+	b, _ := json.MarshalIndent(report, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
+
+// APIStatus maps an error to the HTTP status the API server answers with,
+// along the lines of its exit status.
+func APIStatus(err error) int {
+	var badRequest *BadRequestError
+	if errors.As(err, &badRequest) {
+		return http.StatusBadRequest
+	}
+
+	switch ExitCode(err) {
+	case ExitConfig:
+		return http.StatusBadRequest
+	case ExitItemActionRequired:
+		return http.StatusConflict
+	case ExitRateLimited:
+		return http.StatusTooManyRequests
+	case ExitNetwork:
+		return http.StatusBadGateway
+	}
+
+	var itemErr *ItemError
+	if errors.As(err, &itemErr) && itemErr.Remediation == RemediationWaitAndRetry {
+		return http.StatusServiceUnavailable
+	}
+
+	var plaidErr plaid.Error
+	if errors.As(err, &plaidErr) {
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
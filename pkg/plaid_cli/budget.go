@@ -0,0 +1,174 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Budget statuses.
+const (
+	BudgetOK        = "ok"
+	BudgetProjected = "projected_over"
+	BudgetAlert     = "alert"
+	BudgetOver      = "over"
+)
+
+// Budget is a monthly spending limit for a category or a tag, defined under
+// budgets.NAME in the config file.
+type Budget struct {
+	Name     string  `mapstructure:"-"`
+	Category string  `mapstructure:"category"`
+	Tag      string  `mapstructure:"tag"`
+	Limit    float64 `mapstructure:"limit"`
+	// Rollover carries what was left, or overspent, last month over to
+	// this month.
+	Rollover bool `mapstructure:"rollover"`
+	// AlertPercent overrides budget.alert_percent for this budget.
+	AlertPercent int `mapstructure:"alert_percent"`
+}
+
+// NewBudgets validates budgets read from config and names them.
+func NewBudgets(config map[string]Budget) ([]Budget, error) {
+	var budgets []Budget
+	for name, b := range config {
+		b.Name = name
+		if b.Category == "" && b.Tag == "" {
+			return nil, NewConfigError("Budget %s needs a category or a tag.", name)
+		}
+		if b.Limit <= 0 {
+			return nil, NewConfigError("Budget %s needs a positive limit.", name)
+		}
+		if b.AlertPercent < 0 {
+			return nil, NewConfigError("Budget %s has a negative alert_percent.", name)
+		}
+		budgets = append(budgets, b)
+	}
+
+	sort.Slice(budgets, func(i, j int) bool {
+		return budgets[i].Name < budgets[j].Name
+	})
+
+	return budgets, nil
+}
+
+// Matches reports whether a transaction counts against the budget.
+func (b Budget) Matches(tx Transaction) bool {
+	if b.Category != "" && HasCategoryPrefix(tx.Category, ParseCategory(b.Category)) {
+		return true
+	}
+
+	return b.Tag != "" && tx.HasTag(b.Tag)
+}
+
+// spent sums what matching transactions in r took out of the accounts.
+// Refunds reduce it.
+func (b Budget) spent(txs []Transaction, r DateRange) float64 {
+	from := r.From.Format(DateFormat)
+	to := r.To.Format(DateFormat)
+
+	total := 0.0
+	for _, tx := range txs {
+		if tx.Date >= from && tx.Date <= to && b.Matches(tx) {
+			total += tx.Amount
+		}
+	}
+
+	return total
+}
+
+// BudgetStatus is how a budget is doing in a month.
+type BudgetStatus struct {
+	Name     string  `json:"name"`
+	Month    string  `json:"month"`
+	Limit    float64 `json:"limit"`
+	Rollover float64 `json:"rollover"`
+	// Available is the limit plus what rolled over.
+	Available float64 `json:"available"`
+	Spent     float64 `json:"spent"`
+	Remaining float64 `json:"remaining"`
+	// Projected extrapolates the spending so far to the end of the month.
+	Projected    float64 `json:"projected"`
+	Percent      float64 `json:"percent"`
+	AlertPercent int     `json:"alert_percent"`
+	Status       string  `json:"status"`
+}
+
+// Alerting reports whether the budget crossed its alert threshold.
+func (s BudgetStatus) Alerting() bool {
+	return s.Status == BudgetAlert || s.Status == BudgetOver
+}
+
+// EvaluateBudgets compares spending in month against each budget.
+// Transactions from the previous month are needed for budgets that roll
+// over.
+func EvaluateBudgets(budgets []Budget, txs []Transaction, month DateRange, now time.Time, alertPercent int) []BudgetStatus {
+	previous := DateRange{month.From.AddDate(0, -1, 0), month.From.AddDate(0, 0, -1)}
+
+	// The share of the month that has passed, to project spending.
+	today := midnight(now)
+	days := month.To.Sub(month.From).Hours()/24 + 1
+	elapsed := days
+	if !today.After(month.To) {
+		elapsed = today.Sub(month.From).Hours()/24 + 1
+	}
+	if elapsed < 1 {
+		elapsed = 1
+	}
+
+	var statuses []BudgetStatus
+	for _, b := range budgets {
+		s := BudgetStatus{
+			Name:         b.Name,
+			Month:        month.From.Format(MonthFormat),
+			Limit:        b.Limit,
+			AlertPercent: alertPercent,
+		}
+		if b.AlertPercent > 0 {
+			s.AlertPercent = b.AlertPercent
+		}
+
+		if b.Rollover {
+			s.Rollover = b.Limit - b.spent(txs, previous)
+		}
+
+		s.Available = s.Limit + s.Rollover
+		s.Spent = b.spent(txs, month)
+		s.Remaining = s.Available - s.Spent
+		s.Projected = s.Spent / elapsed * days
+
+		if s.Available > 0 {
+			s.Percent = s.Spent / s.Available * 100
+		} else if s.Spent > 0 {
+			s.Percent = 100
+		}
+
+		switch {
+		case s.Spent > s.Available:
+			s.Status = BudgetOver
+		case s.Percent >= float64(s.AlertPercent):
+			s.Status = BudgetAlert
+		case s.Projected > s.Available:
+			s.Status = BudgetProjected
+		default:
+			s.Status = BudgetOK
+		}
+
+		statuses = append(statuses, s)
+	}
+
+	return statuses
+}
+
+// Describe is a one-line summary of the status, for notifications.
+func (s BudgetStatus) Describe() string {
+	return fmt.Sprintf("Budget %s: spent %.2f of %.2f (%.0f%%) in %s", s.Name, s.Spent, s.Available, s.Percent, s.Month)
+}
+
+// BudgetNotification is a budget that crossed its alert threshold, as passed to
+// budget.notify_command.
+type BudgetNotification struct {
+	BudgetStatus
+	// Message is the status in one line, see Describe.
+	Message string `json:"message"`
+}
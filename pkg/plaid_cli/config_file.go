@@ -0,0 +1,47 @@
+package plaid_cli
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// ReadConfigFile reads only the TOML config file at path, without defaults or
+// environment variables. A missing file reads as empty.
+func ReadConfigFile(path string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("toml")
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return v, nil
+	}
+
+	return v, v.ReadInConfig()
+}
+
+// UpdateConfigFile sets values in the TOML config file at path, creating it
+// if needed. Keys are dotted paths like "plaid.client_id". The file is only
+// readable by its owner since it may contain secrets.
+func UpdateConfigFile(path string, values map[string]interface{}) error {
+	v, err := ReadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	v.SetConfigPermissions(0600)
+
+	for key, value := range values {
+		v.Set(key, value)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if err := v.WriteConfigAs(path); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,218 @@
+package plaid_cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the rates file in the data directory, in order of preference.
+var ratesFileNames = []string{"rates.csv", "rates.json"}
+
+// Currency returns the ISO currency code of a transaction, or the
+// unofficial one Plaid reports for currencies like cryptocurrencies.
+func Currency(tx Transaction) string {
+	if tx.ISOCurrencyCode != "" {
+		return tx.ISOCurrencyCode
+	}
+
+	return tx.UnofficialCurrencyCode
+}
+
+// Currencies lists the currencies of transactions, sorted.
+func Currencies(txs []Transaction) []string {
+	seen := map[string]bool{}
+	var currencies []string
+	for _, tx := range txs {
+		if c := Currency(tx); c != "" && !seen[c] {
+			seen[c] = true
+			currencies = append(currencies, c)
+		}
+	}
+	sort.Strings(currencies)
+
+	return currencies
+}
+
+// CheckSingleCurrency returns an error if transactions in different
+// currencies would be added up.
+func CheckSingleCurrency(txs []Transaction) error {
+	if currencies := Currencies(txs); len(currencies) > 1 {
+		return fmt.Errorf("Transactions are in several currencies (%s) and can't be added up. Use --convert-to to convert them to one.", strings.Join(currencies, ", "))
+	}
+
+	return nil
+}
+
+// Rate is an exchange rate on a date: one unit of From is worth Rate units
+// of To.
+type Rate struct {
+	Date string  `json:"date"`
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"`
+}
+
+// Rates are exchange rates by currency pair, sorted by date.
+type Rates map[string][]Rate
+
+func ratePair(from string, to string) string {
+	return strings.ToUpper(from) + "/" + strings.ToUpper(to)
+}
+
+// RatesPath returns the configured rates file, or the one in dataDir, or ""
+// if there isn't one.
+func RatesPath(dataDir string, configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	for _, name := range ratesFileNames {
+		path := filepath.Join(dataDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// ReadRatesFile reads exchange rates from a CSV file with date, from, to and
+// rate columns, or a JSON array of objects with the same fields.
+func ReadRatesFile(path string) (Rates, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, NewConfigError("Couldn't read exchange rates: %s", err)
+	}
+
+	var list []Rate
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(b, &list); err != nil {
+			return nil, NewConfigError("Invalid exchange rates in %s: %s", path, err)
+		}
+	} else {
+		r := csv.NewReader(strings.NewReader(string(b)))
+		r.Comment = '#'
+		r.TrimLeadingSpace = true
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, NewConfigError("Invalid exchange rates in %s: %s", path, err)
+		}
+
+		for i, record := range records {
+			if len(record) != 4 {
+				return nil, NewConfigError("Invalid exchange rates in %s, line %d: expected date,from,to,rate", path, i+1)
+			}
+			if i == 0 && strings.EqualFold(record[0], "date") {
+				continue
+			}
+
+			rate, err := strconv.ParseFloat(record[3], 64)
+			if err != nil {
+				return nil, NewConfigError("Invalid exchange rates in %s, line %d: %s", path, i+1, err)
+			}
+			list = append(list, Rate{Date: record[0], From: record[1], To: record[2], Rate: rate})
+		}
+	}
+
+	rates := Rates{}
+	for _, rate := range list {
+		if _, err := parseDay(rate.Date); err != nil {
+			return nil, NewConfigError("Invalid date %q in %s. Use YYYY-MM-DD.", rate.Date, path)
+		}
+		if rate.From == "" || rate.To == "" || rate.Rate <= 0 {
+			return nil, NewConfigError("Invalid exchange rate on %s in %s: currencies and a positive rate are needed.", rate.Date, path)
+		}
+
+		pair := ratePair(rate.From, rate.To)
+		rates[pair] = append(rates[pair], rate)
+	}
+	for _, list := range rates {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Date < list[j].Date
+		})
+	}
+
+	return rates, nil
+}
+
+// Rate returns how many units of to one unit of from was worth on date: the
+// latest rate on or before it. A rate the other way around is inverted.
+func (r Rates) Rate(from string, to string, date string) (float64, error) {
+	if strings.EqualFold(from, to) {
+		return 1, nil
+	}
+
+	if rate, ok := latestRate(r[ratePair(from, to)], date); ok {
+		return rate, nil
+	}
+	if rate, ok := latestRate(r[ratePair(to, from)], date); ok {
+		return 1 / rate, nil
+	}
+
+	return 0, NewConfigError("No exchange rate from %s to %s on or before %s.", from, to, date)
+}
+
+func latestRate(list []Rate, date string) (float64, bool) {
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].Date > date
+	})
+	if i == 0 {
+		return 0, false
+	}
+
+	return list[i-1].Rate, true
+}
+
+// Converter converts transactions to a single currency. A nil Converter
+// leaves them alone.
+type Converter struct {
+	To    string
+	Rates Rates
+}
+
+// Convert converts amounts in place, at the rate of each transaction's
+// date, keeping the original amount and currency.
+func (c *Converter) Convert(txs []Transaction) error {
+	if c == nil {
+		return nil
+	}
+
+	for i := range txs {
+		tx := &txs[i]
+		from := Currency(*tx)
+		if from == "" || strings.EqualFold(from, c.To) {
+			continue
+		}
+
+		rate, err := c.Rates.Rate(from, c.To, tx.Date)
+		if err != nil {
+			return err
+		}
+
+		tx.OriginalAmount = tx.Amount
+		tx.OriginalCurrency = from
+		tx.Amount = math.Round(tx.Amount*rate*100) / 100
+		tx.ISOCurrencyCode = strings.ToUpper(c.To)
+		tx.UnofficialCurrencyCode = ""
+	}
+
+	return nil
+}
+
+// NativeAmount is the amount of a transaction in its account's currency,
+// before any conversion.
+func NativeAmount(tx Transaction) float64 {
+	if tx.OriginalCurrency != "" {
+		return tx.OriginalAmount
+	}
+
+	return tx.Amount
+}
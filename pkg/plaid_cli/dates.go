@@ -0,0 +1,160 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateFormat is the format Plaid uses for dates.
+const DateFormat = "2006-01-02"
+
+var relativeDateRegexp = regexp.MustCompile(`^-?(\d+)([dwmy])$`)
+
+// DateRange is an inclusive range of days.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r DateRange) String() string {
+	return fmt.Sprintf("%s to %s", r.From.Format(DateFormat), r.To.Format(DateFormat))
+}
+
+func dateHelp(s string) error {
+	return fmt.Errorf("Invalid date `%s`. Use YYYY-MM-DD, a relative date like -7d, 2w, 3m or 1y, or one of today, yesterday, this-month, last-month, ytd, last-year.", s)
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// addMonths is like AddDate, but clamps to the end of the month instead of
+// overflowing into the next one (e.g. one month before March 31 is
+// February 28).
+func addMonths(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()).AddDate(0, months, 0)
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, 0, 0, 0, 0, t.Location())
+}
+
+// ParseNamedRange parses ranges like this-month and ytd.
+func ParseNamedRange(s string, now time.Time) (DateRange, bool) {
+	today := midnight(now)
+	startOfMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	startOfYear := time.Date(today.Year(), 1, 1, 0, 0, 0, 0, today.Location())
+
+	switch strings.ToLower(s) {
+	case "this-month":
+		return DateRange{startOfMonth, today}, true
+	case "last-month":
+		return DateRange{startOfMonth.AddDate(0, -1, 0), startOfMonth.AddDate(0, 0, -1)}, true
+	case "ytd", "this-year":
+		return DateRange{startOfYear, today}, true
+	case "last-year":
+		return DateRange{startOfYear.AddDate(-1, 0, 0), startOfYear.AddDate(0, 0, -1)}, true
+	default:
+		return DateRange{}, false
+	}
+}
+
+// ParseDate parses an absolute date (YYYY-MM-DD), a relative date in the past
+// (-7d, 30d, 2w, 3m, 1y) or today/yesterday.
+func ParseDate(s string, now time.Time) (time.Time, error) {
+	today := midnight(now)
+
+	switch strings.ToLower(s) {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+
+	if m := relativeDateRegexp.FindStringSubmatch(strings.ToLower(s)); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, dateHelp(s)
+		}
+
+		switch m[2] {
+		case "d":
+			return today.AddDate(0, 0, -n), nil
+		case "w":
+			return today.AddDate(0, 0, -7*n), nil
+		case "m":
+			return addMonths(today, -n), nil
+		case "y":
+			return addMonths(today, -12*n), nil
+		}
+	}
+
+	t, err := time.ParseInLocation(DateFormat, s, now.Location())
+	if err != nil {
+		return time.Time{}, dateHelp(s)
+	}
+
+	return t, nil
+}
+
+// ResolveDateRange turns --from and --to into a range. from may also be a
+// named range, in which case it provides the end date unless to is given.
+func ResolveDateRange(from string, to string, now time.Time) (DateRange, error) {
+	var r DateRange
+
+	if named, ok := ParseNamedRange(from, now); ok {
+		r = named
+	} else {
+		t, err := ParseDate(from, now)
+		if err != nil {
+			return r, err
+		}
+		r.From = t
+		r.To = midnight(now)
+	}
+
+	if to != "" {
+		t, err := ParseDate(to, now)
+		if err != nil {
+			return r, err
+		}
+		r.To = t
+	}
+
+	if r.From.After(r.To) {
+		return r, fmt.Errorf("Invalid date range: %s is after %s.", r.From.Format(DateFormat), r.To.Format(DateFormat))
+	}
+
+	return r, nil
+}
+
+// MonthFormat is the format of --month.
+const MonthFormat = "2006-01"
+
+// ParseMonth parses a month (YYYY-MM, this-month or last-month) and returns
+// its full range, even if it hasn't ended yet.
+func ParseMonth(s string, now time.Time) (DateRange, error) {
+	today := midnight(now)
+	start := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+
+	switch strings.ToLower(s) {
+	case "", "this-month":
+	case "last-month":
+		start = start.AddDate(0, -1, 0)
+	default:
+		t, err := time.ParseInLocation(MonthFormat, s, now.Location())
+		if err != nil {
+			return DateRange{}, fmt.Errorf("Invalid month `%s`. Use YYYY-MM, this-month or last-month.", s)
+		}
+		start = t
+	}
+
+	return DateRange{start, start.AddDate(0, 1, -1)}, nil
+}
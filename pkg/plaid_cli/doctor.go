@@ -0,0 +1,220 @@
+package plaid_cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Severity of a health check result.
+type Severity string
+
+const (
+	SeverityOK      Severity = "ok"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Check is the outcome of a single health check.
+type Check struct {
+	Name     string   `json:"name"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// ItemHealth describes the state of a linked item.
+type ItemHealth struct {
+	ItemID                       string     `json:"item_id"`
+	Alias                        string     `json:"alias,omitempty"`
+	InstitutionID                string     `json:"institution_id,omitempty"`
+	InstitutionName              string     `json:"institution_name,omitempty"`
+	InstitutionStatus            string     `json:"institution_status,omitempty"`
+	ErrorCode                    string     `json:"error_code,omitempty"`
+	Remediation                  string     `json:"remediation,omitempty"`
+	ConsentExpiration            *time.Time `json:"consent_expiration,omitempty"`
+	LastTransactionsUpdate       *time.Time `json:"last_transactions_update,omitempty"`
+	LastFailedTransactionsUpdate *time.Time `json:"last_failed_transactions_update,omitempty"`
+	Severity                     Severity   `json:"severity"`
+	Problems                     []string   `json:"problems,omitempty"`
+}
+
+func (h *ItemHealth) problem(severity Severity, format string, a ...interface{}) {
+	h.Problems = append(h.Problems, fmt.Sprintf(format, a...))
+	if severity == SeverityError || h.Severity == SeverityOK {
+		h.Severity = severity
+	}
+}
+
+// HealthThresholds configures when an item is reported as about to break.
+type HealthThresholds struct {
+	// ConsentWarning is how long before consent expiration to warn.
+	ConsentWarning time.Duration
+	// StaleAfter is how old the last successful transactions update may be.
+	StaleAfter time.Duration
+}
+
+// DoctorReport is the result of `plaid-cli doctor`.
+type DoctorReport struct {
+	Config []Check       `json:"config"`
+	Data   []Check       `json:"data"`
+	Items  []*ItemHealth `json:"items"`
+}
+
+// OK reports whether every check passed.
+func (r *DoctorReport) OK() bool {
+	for _, checks := range [][]Check{r.Config, r.Data} {
+		for _, c := range checks {
+			if c.Severity != SeverityOK {
+				return false
+			}
+		}
+	}
+
+	for _, item := range r.Items {
+		if item.Severity != SeverityOK {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckItem fetches an item and reports anything that will break it soon.
+func CheckItem(client *plaid.Client, data *Data, itemID string, env ItemEnvironment, countries []string, thresholds HealthThresholds, now time.Time) *ItemHealth {
+	health := &ItemHealth{
+		ItemID:   itemID,
+		Alias:    data.BackAliases[itemID],
+		Severity: SeverityOK,
+	}
+
+	if err := data.CheckItemEnvironment(itemID, env); err != nil {
+		health.problem(SeverityError, "%s", err)
+		return health
+	}
+
+	res, err := client.GetItem(data.Tokens[itemID])
+	if err != nil {
+		if itemErr := ClassifyError(itemID, err); itemErr != nil {
+			health.ErrorCode = itemErr.Err.ErrorCode
+			health.Remediation = itemErr.Remediation.String()
+		}
+		health.problem(SeverityError, "%s", err)
+		return health
+	}
+
+	item := res.Item
+	health.InstitutionID = item.InstitutionID
+
+	if item.Error.ErrorCode != "" {
+		itemErr := ClassifyError(itemID, item.Error)
+		health.ErrorCode = item.Error.ErrorCode
+		health.Remediation = itemErr.Remediation.String()
+		health.problem(SeverityError, "%s", itemErr)
+	}
+
+	if !item.ConsentExpirationTime.IsZero() {
+		expiration := item.ConsentExpirationTime
+		health.ConsentExpiration = &expiration
+
+		if expiration.Before(now) {
+			health.problem(SeverityError, "Consent expired on %s", expiration.Format("2006-01-02"))
+		} else if expiration.Sub(now) < thresholds.ConsentWarning {
+			health.problem(SeverityWarning, "Consent expires on %s", expiration.Format("2006-01-02"))
+		}
+	}
+
+	transactions := res.Status.Transactions
+	if !transactions.LastSuccessfulUpdate.IsZero() {
+		last := transactions.LastSuccessfulUpdate
+		health.LastTransactionsUpdate = &last
+
+		if now.Sub(last) > thresholds.StaleAfter {
+			health.problem(SeverityWarning, "Transactions haven't been updated since %s", last.Format(time.RFC3339))
+		}
+	}
+	if !transactions.LastFailedUpdate.IsZero() {
+		last := transactions.LastFailedUpdate
+		health.LastFailedTransactionsUpdate = &last
+	}
+
+	if item.InstitutionID != "" {
+		instRes, err := client.GetInstitutionByIDWithOptions(item.InstitutionID, countries, plaid.GetInstitutionByIDOptions{
+			IncludeStatus: true,
+		})
+		if err != nil {
+			health.problem(SeverityWarning, "Couldn't fetch institution status: %s", err)
+			return health
+		}
+
+		health.InstitutionName = instRes.Institution.Name
+		if status := instRes.Institution.InstitutionStatus; status != nil {
+			health.InstitutionStatus = status.ItemLogins.Status
+			if status.ItemLogins.Status != "" && status.ItemLogins.Status != "HEALTHY" {
+				health.problem(SeverityWarning, "Institution logins are %s", status.ItemLogins.Status)
+			}
+		}
+	}
+
+	return health
+}
+
+// Verify checks the integrity of the data directory.
+func (d *Data) Verify() []Check {
+	var checks []Check
+
+	files := []struct {
+		name string
+		path string
+	}{
+		{"tokens", d.tokensPath()},
+		{"aliases", d.aliasesPath()},
+		{"items", d.itemsPath()},
+		{"last runs", d.lastRunsPath()},
+		{"annotations", d.annotationsPath()},
+		{"reported anomalies", d.reportedAnomaliesPath()},
+		{"sync cursors", d.syncCursorsPath()},
+	}
+
+	for _, file := range files {
+		check := Check{Name: file.name, Severity: SeverityOK, Message: file.path}
+
+		b, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			check.Severity = SeverityError
+			check.Message = err.Error()
+		} else if len(b) > 0 {
+			var v map[string]interface{}
+			if err := json.Unmarshal(b, &v); err != nil {
+				check.Severity = SeverityError
+				check.Message = fmt.Sprintf("%s is corrupt: %s", file.path, err)
+			}
+		}
+
+		checks = append(checks, check)
+	}
+
+	for itemID, token := range d.Tokens {
+		if token == "" {
+			checks = append(checks, Check{
+				Name:     "tokens",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Item %s has an empty access token", itemID),
+			})
+		}
+	}
+
+	for alias, itemID := range d.Aliases {
+		if _, ok := d.Tokens[itemID]; !ok {
+			checks = append(checks, Check{
+				Name:     "aliases",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("Alias %s points to item %s, which has no access token", alias, itemID),
+			})
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,138 @@
+package plaid_cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/manifoldco/promptui"
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Exit statuses. These are part of plaid-cli's interface, so don't change
+// existing values.
+const (
+	ExitOK                 = 0
+	ExitError              = 1
+	ExitConfig             = 2
+	ExitItemActionRequired = 3
+	ExitRateLimited        = 4
+	ExitNetwork            = 5
+	ExitAborted            = 6
+	// ExitAlert means the command worked and found something to alert
+	// about, like a budget over its threshold.
+	ExitAlert = 7
+)
+
+// ErrAborted is returned when the user declines to continue.
+var ErrAborted = errors.New("Aborted")
+
+// ConfigError is returned when plaid-cli is misconfigured.
+type ConfigError struct {
+	Message string
+}
+
+func NewConfigError(format string, a ...interface{}) *ConfigError {
+	return &ConfigError{Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *ConfigError) Error() string {
+	return e.Message
+}
+
+// ErrorReport is the machine-readable form of an error, printed with
+// --error-format json.
+type ErrorReport struct {
+	ErrorType   string `json:"error_type"`
+	ErrorCode   string `json:"error_code,omitempty"`
+	Message     string `json:"message"`
+	ItemID      string `json:"item_id,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+	ExitCode    int    `json:"exit_code"`
+}
+
+func NewErrorReport(err error) ErrorReport {
+	report := ErrorReport{
+		ErrorType: "CLI_ERROR",
+		Message:   err.Error(),
+		ExitCode:  ExitCode(err),
+	}
+
+	var itemErr *ItemError
+	if errors.As(err, &itemErr) {
+		report.ItemID = itemErr.ItemID
+		if itemErr.Remediation != RemediationNone {
+			report.Remediation = itemErr.Remediation.String()
+		}
+	}
+
+	var plaidErr plaid.Error
+	var configErr *ConfigError
+	switch {
+	case errors.As(err, &plaidErr):
+		report.ErrorType = plaidErr.ErrorType
+		report.ErrorCode = plaidErr.ErrorCode
+		report.RequestID = plaidErr.RequestID
+	case errors.As(err, &configErr):
+		report.ErrorType = "CONFIG_ERROR"
+	case isAborted(err):
+		report.ErrorType = "ABORTED"
+	case isNetworkError(err):
+		report.ErrorType = "NETWORK_ERROR"
+	}
+
+	return report
+}
+
+// ExitCode maps an error to the exit status plaid-cli should use.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var itemErr *ItemError
+	if errors.As(err, &itemErr) && itemErr.Remediation.NeedsAction() {
+		return ExitItemActionRequired
+	}
+
+	var configErr *ConfigError
+	if errors.As(err, &configErr) {
+		return ExitConfig
+	}
+
+	var plaidErr plaid.Error
+	if errors.As(err, &plaidErr) {
+		switch {
+		case plaidErr.ErrorType == "RATE_LIMIT_EXCEEDED":
+			return ExitRateLimited
+		case plaidErr.ErrorCode == "INVALID_API_KEYS":
+			return ExitConfig
+		}
+		return ExitError
+	}
+
+	if isAborted(err) {
+		return ExitAborted
+	}
+
+	if isNetworkError(err) {
+		return ExitNetwork
+	}
+
+	return ExitError
+}
+
+func isAborted(err error) bool {
+	return errors.Is(err, ErrAborted) ||
+		errors.Is(err, promptui.ErrInterrupt) ||
+		errors.Is(err, promptui.ErrEOF) ||
+		errors.Is(err, promptui.ErrAbort) ||
+		errors.Is(err, context.Canceled)
+}
+
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
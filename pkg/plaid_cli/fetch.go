@@ -0,0 +1,247 @@
+package plaid_cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// MaxTransactionsPageSize is the largest count /transactions/get accepts.
+const MaxTransactionsPageSize = 500
+
+// DefaultFetchConcurrency is how many pages TransactionFetcher requests at
+// once by default.
+const DefaultFetchConcurrency = 4
+
+// TransactionFetcher fetches every page of /transactions/get. The first page
+// tells how many transactions there are, the remaining pages are then
+// fetched concurrently.
+type TransactionFetcher struct {
+	// Options configure the Plaid clients used for each page, which are
+	// bound to the fetch's context.
+	Options plaid.ClientOptions
+	// Concurrency is the number of pages fetched at once.
+	Concurrency int
+	// Progress, when set, receives a progress indicator.
+	Progress io.Writer
+}
+
+func NewTransactionFetcher(opts plaid.ClientOptions) *TransactionFetcher {
+	return &TransactionFetcher{
+		Options:     opts,
+		Concurrency: DefaultFetchConcurrency,
+	}
+}
+
+// page fetches a single page. Canceling ctx interrupts the request and any
+// retries.
+func (f *TransactionFetcher) page(ctx context.Context, token string, opts plaid.GetTransactionsOptions) (plaid.GetTransactionsResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return plaid.GetTransactionsResponse{}, err
+	}
+
+	client, err := NewClientWithContext(ctx, f.Options)
+	if err != nil {
+		return plaid.GetTransactionsResponse{}, err
+	}
+
+	res, err := client.GetTransactionsWithOptions(token, opts)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return plaid.GetTransactionsResponse{}, ctxErr
+	}
+
+	return res, err
+}
+
+func (f *TransactionFetcher) progress(fetched, total int) {
+	if f.Progress == nil {
+		return
+	}
+
+	fmt.Fprintf(f.Progress, "\rFetching transactions... %d/%d", fetched, total)
+}
+
+func (f *TransactionFetcher) clearProgress() {
+	if f.Progress == nil {
+		return
+	}
+
+	fmt.Fprint(f.Progress, "\r\033[K")
+}
+
+// All fetches every transaction matching opts. Count and Offset are ignored.
+func (f *TransactionFetcher) All(ctx context.Context, token string, opts plaid.GetTransactionsOptions) ([]plaid.Transaction, error) {
+	transactions := []plaid.Transaction{}
+	err := f.Each(ctx, token, opts, func(page []plaid.Transaction) error {
+		transactions = append(transactions, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// Each calls fn with every page of transactions matching opts, in order.
+// Only a few pages are held in memory at once. Count and Offset are ignored.
+func (f *TransactionFetcher) Each(ctx context.Context, token string, opts plaid.GetTransactionsOptions, fn func([]plaid.Transaction) error) error {
+	defer f.clearProgress()
+
+	opts.Count = MaxTransactionsPageSize
+	opts.Offset = 0
+
+	first, err := f.page(ctx, token, opts)
+	if err != nil {
+		return err
+	}
+
+	// Pages can overlap when transactions are added while fetching, shifting
+	// offsets, so transactions already seen on the previous page are
+	// skipped. Only two pages of IDs are kept, whatever the export's size.
+	total := first.TotalTransactions
+	var previous map[string]bool
+	fetched := 0
+
+	emit := func(res plaid.GetTransactionsResponse) error {
+		if res.TotalTransactions > total {
+			total = res.TotalTransactions
+		}
+
+		current := make(map[string]bool, len(res.Transactions))
+		fresh := make([]plaid.Transaction, 0, len(res.Transactions))
+		for _, tx := range res.Transactions {
+			if tx.ID != "" {
+				if previous[tx.ID] || current[tx.ID] {
+					continue
+				}
+				current[tx.ID] = true
+			}
+			fresh = append(fresh, tx)
+		}
+		previous = current
+
+		fetched += len(fresh)
+		f.progress(fetched, total)
+
+		if len(fresh) == 0 {
+			return nil
+		}
+		return fn(fresh)
+	}
+
+	if err := emit(first); err != nil {
+		return err
+	}
+
+	var offsets []int
+	for offset := opts.Count; offset < total; offset += opts.Count {
+		offsets = append(offsets, offset)
+	}
+
+	if err := f.pages(ctx, token, opts, offsets, emit); err != nil {
+		return err
+	}
+
+	// Pick up whatever shifted past the pages fetched so far, one page at a
+	// time. A page that brings nothing new ends the loop, since Plaid's total
+	// can be off.
+	for fetched < total {
+		opts.Offset = fetched
+		res, err := f.page(ctx, token, opts)
+		if err != nil {
+			return err
+		}
+
+		before := fetched
+		if err := emit(res); err != nil {
+			return err
+		}
+
+		if fetched == before {
+			f.clearProgress()
+			log.Printf("Plaid reported %d transactions but only returned %d.", total, fetched)
+			break
+		}
+	}
+
+	return nil
+}
+
+// pages fetches the pages at offsets concurrently and passes them to fn in
+// order. At most Concurrency pages are in flight or waiting for fn. The first
+// error cancels the remaining requests.
+func (f *TransactionFetcher) pages(ctx context.Context, token string, opts plaid.GetTransactionsOptions, offsets []int, fn func(plaid.GetTransactionsResponse) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := f.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*plaid.GetTransactionsResponse, len(offsets))
+	next := 0
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i, offset := range offsets {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, offset int) {
+			defer wg.Done()
+
+			pageOpts := opts
+			pageOpts.Offset = offset
+			res, err := f.page(ctx, token, pageOpts)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			results[i] = &res
+			for next < len(results) && results[next] != nil && firstErr == nil {
+				if err := fn(*results[next]); err != nil {
+					fail(err)
+					return
+				}
+				results[next] = nil
+				next++
+				<-sem
+			}
+		}(i, offset)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,175 @@
+package plaid_cli
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Categories treated as transfers between the user's own accounts.
+var transferCategories = [][]string{
+	{"Transfer"},
+	{"Payment", "Credit Card"},
+}
+
+// TransactionFilter selects transactions client-side. The zero value matches
+// everything.
+type TransactionFilter struct {
+	// Pending, when set, only matches pending (true) or posted (false)
+	// transactions.
+	Pending *bool
+	// Min and Max bound the amount as reported by Plaid, where money moving
+	// out of an account is positive.
+	Min *float64
+	Max *float64
+	// Categories match if any of them is a prefix of the transaction's
+	// category hierarchy, like "Food and Drink > Restaurants".
+	Categories []string
+	// Merchants match the merchant name, or the name if Plaid didn't
+	// detect a merchant, case-insensitively.
+	Merchants []string
+	// Tags match if the transaction has any of them.
+	Tags []string
+	// Reimbursable only matches transactions marked reimbursable.
+	Reimbursable bool
+	// Search matches the name, merchant name, payee or note.
+	Search *regexp.Regexp
+	// ExcludeTransfers drops transfers between accounts, including the
+	// ones paired by PairTransfers, and credit card payments.
+	ExcludeTransfers bool
+}
+
+// ParseCategory splits a category like "Food and Drink > Restaurants" into
+// its hierarchy.
+func ParseCategory(category string) []string {
+	var levels []string
+	for _, level := range strings.Split(category, ">") {
+		if level = strings.TrimSpace(level); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// HasCategoryPrefix reports whether prefix is a prefix of the category
+// hierarchy, ignoring case.
+func HasCategoryPrefix(category []string, prefix []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(category) {
+		return false
+	}
+
+	for i, level := range prefix {
+		if !strings.EqualFold(category[i], level) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsTransfer reports whether a transaction moves money between accounts.
+func IsTransfer(tx Transaction) bool {
+	if tx.Transfer {
+		return true
+	}
+
+	for _, category := range transferCategories {
+		if HasCategoryPrefix(tx.Category, category) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Merchant returns the payee set by a rule or the merchant name, falling
+// back to the transaction's name.
+func Merchant(tx Transaction) string {
+	if tx.Payee != "" {
+		return tx.Payee
+	}
+	if tx.MerchantName != "" {
+		return tx.MerchantName
+	}
+
+	return tx.Name
+}
+
+func (f *TransactionFilter) Match(tx Transaction) bool {
+	if f.Pending != nil && tx.Pending != *f.Pending {
+		return false
+	}
+
+	if f.Min != nil && tx.Amount < *f.Min {
+		return false
+	}
+
+	if f.Max != nil && tx.Amount > *f.Max {
+		return false
+	}
+
+	if len(f.Categories) > 0 {
+		matched := false
+		for _, category := range f.Categories {
+			if HasCategoryPrefix(tx.Category, ParseCategory(category)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.Merchants) > 0 {
+		merchant := Merchant(tx)
+		matched := false
+		for _, m := range f.Merchants {
+			if strings.EqualFold(merchant, m) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(f.Tags) > 0 {
+		matched := false
+		for _, tag := range f.Tags {
+			if tx.HasTag(tag) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if f.Reimbursable && !tx.Reimbursable {
+		return false
+	}
+
+	if f.Search != nil && !f.Search.MatchString(tx.Name) && !f.Search.MatchString(tx.MerchantName) && !f.Search.MatchString(tx.Payee) && !f.Search.MatchString(tx.Note) {
+		return false
+	}
+
+	if f.ExcludeTransfers && IsTransfer(tx) {
+		return false
+	}
+
+	return true
+}
+
+// Apply returns the transactions matching the filter.
+func (f *TransactionFilter) Apply(txs []Transaction) []Transaction {
+	matched := make([]Transaction, 0, len(txs))
+	for _, tx := range txs {
+		if f.Match(tx) {
+			matched = append(matched, tx)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,41 @@
+package plaid_cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RunHook runs a user-configured shell command, like a notification script,
+// with input on its stdin. Its output goes to plaid-cli's stderr so it never
+// mixes with plaid-cli's own output.
+func RunHook(command string, input []byte, timeout time.Duration) error {
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Hook `%s` failed: %s", command, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("Hook `%s` failed: %s", command, err)
+		}
+		return nil
+	case <-timer.C:
+		cmd.Process.Kill()
+		return fmt.Errorf("Hook `%s` timed out after %s", command, timeout)
+	}
+}
@@ -0,0 +1,193 @@
+package plaid_cli
+
+import (
+	"fmt"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Remediation describes what should be done about a failed Plaid call.
+type Remediation int
+
+const (
+	// RemediationNone means the error isn't an item error plaid-cli knows
+	// how to handle.
+	RemediationNone Remediation = iota
+	// RemediationRelink means the item must go through Link's update mode.
+	RemediationRelink
+	// RemediationRelinkAccountSelection means the item must go through
+	// Link's update mode with account selection enabled.
+	RemediationRelinkAccountSelection
+	// RemediationRemoveToken means the stored access token is no longer
+	// usable and should be forgotten.
+	RemediationRemoveToken
+	// RemediationWaitAndRetry means the problem is likely temporary.
+	RemediationWaitAndRetry
+	// RemediationExplain means the user has to do something outside of
+	// plaid-cli before the item works again.
+	RemediationExplain
+)
+
+func (r Remediation) String() string {
+	switch r {
+	case RemediationRelink:
+		return "relink"
+	case RemediationRelinkAccountSelection:
+		return "relink_account_selection"
+	case RemediationRemoveToken:
+		return "remove_token"
+	case RemediationWaitAndRetry:
+		return "wait_and_retry"
+	case RemediationExplain:
+		return "explain"
+	default:
+		return "none"
+	}
+}
+
+// Interactive reports whether carrying out the remediation needs a human.
+func (r Remediation) Interactive() bool {
+	switch r {
+	case RemediationRelink, RemediationRelinkAccountSelection, RemediationRemoveToken:
+		return true
+	default:
+		return false
+	}
+}
+
+// NeedsAction reports whether the user has to do something before the item
+// works again, in plaid-cli or outside of it.
+func (r Remediation) NeedsAction() bool {
+	return r.Interactive() || r == RemediationExplain
+}
+
+type errorClass struct {
+	Remediation Remediation
+	Explanation string
+}
+
+// See https://plaid.com/docs/errors/
+var errorCodeClasses = map[string]errorClass{
+	"ITEM_LOGIN_REQUIRED": {
+		RemediationRelink,
+		"Login expired. The institution needs you to log in again.",
+	},
+	"PENDING_EXPIRATION": {
+		RemediationRelink,
+		"Access consent for this institution is about to expire.",
+	},
+	"ACCESS_NOT_GRANTED": {
+		RemediationRelink,
+		"plaid-cli wasn't granted access to the data it needs.",
+	},
+	"NO_ACCOUNTS": {
+		RemediationRelinkAccountSelection,
+		"No accounts are shared with plaid-cli for this institution.",
+	},
+	"INVALID_ACCESS_TOKEN": {
+		RemediationRemoveToken,
+		"The stored access token is invalid. It may have been revoked or created in a different Plaid environment.",
+	},
+	"ITEM_NOT_FOUND": {
+		RemediationRemoveToken,
+		"The item no longer exists at Plaid.",
+	},
+	"ITEM_LOCKED": {
+		RemediationExplain,
+		"The account is locked at the institution after too many failed login attempts. Unlock it on the institution's website, then relink with `plaid-cli link`.",
+	},
+	"USER_SETUP_REQUIRED": {
+		RemediationExplain,
+		"The institution requires you to complete an action on its website (like accepting terms or resetting a password). Log in there, then relink with `plaid-cli link`.",
+	},
+	"PRODUCT_NOT_READY": {
+		RemediationWaitAndRetry,
+		"Plaid hasn't finished pulling data for this item yet.",
+	},
+	"INSTITUTION_DOWN": {
+		RemediationWaitAndRetry,
+		"The institution is down.",
+	},
+	"INSTITUTION_NOT_RESPONDING": {
+		RemediationWaitAndRetry,
+		"The institution isn't responding.",
+	},
+	"PLANNED_MAINTENANCE": {
+		RemediationWaitAndRetry,
+		"Plaid or the institution is undergoing planned maintenance.",
+	},
+	"INTERNAL_SERVER_ERROR": {
+		RemediationWaitAndRetry,
+		"Plaid returned an internal server error.",
+	},
+}
+
+var errorTypeClasses = map[string]errorClass{
+	"RATE_LIMIT_EXCEEDED": {
+		RemediationWaitAndRetry,
+		"Plaid's rate limit was exceeded.",
+	},
+	"INSTITUTION_ERROR": {
+		RemediationWaitAndRetry,
+		"The institution returned an error.",
+	},
+	"API_ERROR": {
+		RemediationWaitAndRetry,
+		"Plaid returned an internal error.",
+	},
+}
+
+// ItemError is a Plaid error for an item together with what should be done
+// about it.
+type ItemError struct {
+	ItemID      string
+	Err         plaid.Error
+	Remediation Remediation
+	Explanation string
+}
+
+// ClassifyError maps a Plaid error to a remediation. It returns nil if err
+// isn't a Plaid error.
+func ClassifyError(itemID string, err error) *ItemError {
+	e, ok := err.(plaid.Error)
+	if !ok {
+		return nil
+	}
+
+	class, ok := errorCodeClasses[e.ErrorCode]
+	if !ok {
+		class = errorTypeClasses[e.ErrorType]
+	}
+
+	return &ItemError{
+		ItemID:      itemID,
+		Err:         e,
+		Remediation: class.Remediation,
+		Explanation: class.Explanation,
+	}
+}
+
+func (e *ItemError) Error() string {
+	if e.Explanation == "" {
+		return e.Err.Error()
+	}
+
+	msg := fmt.Sprintf("%s (%s, item %s)", e.Explanation, e.Err.ErrorCode, e.ItemID)
+
+	switch e.Remediation {
+	case RemediationRelink:
+		msg += fmt.Sprintf(" Run `plaid-cli link %s` to relink.", e.ItemID)
+	case RemediationRelinkAccountSelection:
+		msg += fmt.Sprintf(" Run `plaid-cli link %s` to relink and select accounts.", e.ItemID)
+	case RemediationRemoveToken:
+		msg += " Link the institution again with `plaid-cli link`."
+	case RemediationWaitAndRetry:
+		msg += " Please try again later."
+	}
+
+	return msg
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
@@ -1,12 +1,15 @@
 package plaid_cli
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"text/template"
+	"time"
 
 	"github.com/plaid/plaid-go/plaid"
 	"github.com/skratchdot/open-golang/open"
@@ -18,8 +21,11 @@ type Linker struct {
 	Errors        chan error
 	Client        *plaid.Client
 	Data          *Data
-	countries     []string
-	lang          string
+	// Webhook is the URL Plaid notifies about new links. Empty means none.
+	Webhook     string
+	credentials Credentials
+	countries   []string
+	lang        string
 }
 
 type TokenPair struct {
@@ -27,13 +33,54 @@ type TokenPair struct {
 	AccessToken string
 }
 
+// Credentials are the Plaid API keys. They're needed for requests that
+// plaid-go doesn't support.
+type Credentials struct {
+	ClientID string
+	Secret   string
+}
+
+// linkTokenUpdate configures Link's update mode. It isn't part of
+// plaid.LinkTokenConfigs.
+type linkTokenUpdate struct {
+	AccountSelectionEnabled bool `json:"account_selection_enabled"`
+}
+
+type createLinkTokenRequest struct {
+	ClientID string `json:"client_id"`
+	Secret   string `json:"secret"`
+	plaid.LinkTokenConfigs
+	Update *linkTokenUpdate `json:"update,omitempty"`
+}
+
+// Countries returns the country codes passed to Plaid Link.
+func (l *Linker) Countries() []string {
+	return l.countries
+}
+
+// Language returns the language passed to Plaid Link.
+func (l *Linker) Language() string {
+	return l.lang
+}
+
 func (l *Linker) Relink(itemID string, port string) error {
+	return l.relinkItem(itemID, port, nil)
+}
+
+// RelinkWithAccountSelection relinks an item and lets the user change which
+// accounts are shared with plaid-cli.
+func (l *Linker) RelinkWithAccountSelection(itemID string, port string) error {
+	return l.relinkItem(itemID, port, &linkTokenUpdate{AccountSelectionEnabled: true})
+}
+
+func (l *Linker) relinkItem(itemID string, port string, update *linkTokenUpdate) error {
 	token := l.Data.Tokens[itemID]
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	resp, err := l.Client.CreateLinkToken(plaid.LinkTokenConfigs{
+
+	configs := plaid.LinkTokenConfigs{
 		User: &plaid.LinkTokenUser{
 			ClientUserID: hostname,
 		},
@@ -41,17 +88,39 @@ func (l *Linker) Relink(itemID string, port string) error {
 		CountryCodes: l.countries,
 		Language:     l.lang,
 		AccessToken:  token,
-	})
+	}
+
+	var resp plaid.CreateLinkTokenResponse
+	if update == nil {
+		resp, err = l.Client.CreateLinkToken(configs)
+	} else {
+		resp, err = l.createLinkTokenWithUpdate(configs, update)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	return l.relink(port, resp.LinkToken)
 }
 
+func (l *Linker) createLinkTokenWithUpdate(configs plaid.LinkTokenConfigs, update *linkTokenUpdate) (resp plaid.CreateLinkTokenResponse, err error) {
+	jsonBody, err := json.Marshal(createLinkTokenRequest{
+		ClientID:         l.credentials.ClientID,
+		Secret:           l.credentials.Secret,
+		LinkTokenConfigs: configs,
+		Update:           update,
+	})
+	if err != nil {
+		return resp, err
+	}
+
+	err = l.Client.Call("/link/token/create", jsonBody, &resp)
+	return resp, err
+}
+
 func (l *Linker) Link(port string) (*TokenPair, error) {
 	hostname, err := os.Hostname()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	resp, err := l.Client.CreateLinkToken(plaid.LinkTokenConfigs{
 		User: &plaid.LinkTokenUser{
@@ -61,9 +130,10 @@ func (l *Linker) Link(port string) (*TokenPair, error) {
 		Products:     []string{"transactions"},
 		CountryCodes: l.countries,
 		Language:     l.lang,
+		Webhook:      l.Webhook,
 	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	return l.link(port, resp.LinkToken)
 }
@@ -71,13 +141,8 @@ func (l *Linker) Link(port string) (*TokenPair, error) {
 func (l *Linker) link(port string, linkToken string) (*TokenPair, error) {
 	log.Println(fmt.Sprintf("Starting Plaid Link on port %s...", port))
 
-	go func() {
-		http.HandleFunc("/link", handleLink(l, linkToken))
-		err := http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
-		if err != nil {
-			l.Errors <- err
-		}
-	}()
+	server := l.serve(port, "/link", handleLink(l, linkToken))
+	defer shutdown(server)
 
 	url := fmt.Sprintf("http://localhost:%s/link", port)
 	log.Println(fmt.Sprintf("Your browser should open automatically. If it doesn't, please visit %s to continue linking!", url))
@@ -105,13 +170,8 @@ func (l *Linker) link(port string, linkToken string) (*TokenPair, error) {
 func (l *Linker) relink(port string, linkToken string) error {
 	log.Println(fmt.Sprintf("Starting Plaid Link on port %s...", port))
 
-	go func() {
-		http.HandleFunc("/relink", handleRelink(l, linkToken))
-		err := http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
-		if err != nil {
-			l.Errors <- err
-		}
-	}()
+	server := l.serve(port, "/relink", handleRelink(l, linkToken))
+	defer shutdown(server)
 
 	url := fmt.Sprintf("http://localhost:%s/relink", port)
 	log.Println(fmt.Sprintf("Your browser should open automatically. If it doesn't, please visit %s to continue linking!", url))
@@ -125,17 +185,48 @@ func (l *Linker) relink(port string, linkToken string) error {
 	}
 }
 
+// serve serves Link's page and callback at path on port in the background.
+// Every link gets its own server, so that a process can link more than once.
+func (l *Linker) serve(port string, path string, handler http.HandlerFunc) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, handler)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: mux,
+	}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			l.Errors <- err
+		}
+	}()
+
+	return server
+}
+
+// shutdown stops a server started by serve once Link is done with it.
+func shutdown(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+}
+
 func (l *Linker) exchange(publicToken string) (plaid.ExchangePublicTokenResponse, error) {
 	return l.Client.ExchangePublicToken(publicToken)
 }
 
-func NewLinker(data *Data, client *plaid.Client, countries []string, lang string) *Linker {
+func NewLinker(data *Data, client *plaid.Client, credentials Credentials, countries []string, lang string) *Linker {
 	return &Linker{
 		Results:       make(chan string),
 		RelinkResults: make(chan bool),
 		Errors:        make(chan error),
 		Client:        client,
 		Data:          data,
+		credentials:   credentials,
 		countries:     countries,
 		lang:          lang,
 	}
@@ -0,0 +1,85 @@
+package plaid_cli
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Output is where a command writes its results. Nothing is visible at the
+// destination until Commit succeeds when writing to a file.
+type Output interface {
+	io.Writer
+	// Commit finishes the output.
+	Commit() error
+	// Abort discards the output if possible.
+	Abort()
+}
+
+// OpenOutput returns an Output for path, or stdout if path is empty or "-".
+func OpenOutput(path string) (Output, error) {
+	if path == "" || path == "-" {
+		return stdoutOutput{os.Stdout}, nil
+	}
+
+	return CreateAtomicFile(path)
+}
+
+type stdoutOutput struct {
+	io.Writer
+}
+
+func (stdoutOutput) Commit() error { return nil }
+
+func (stdoutOutput) Abort() {}
+
+// AtomicFile writes to a temporary file next to path and renames it into
+// place on Commit, so readers never see a partial file.
+type AtomicFile struct {
+	*os.File
+	path string
+}
+
+func CreateAtomicFile(path string) (*AtomicFile, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AtomicFile{File: f, path: path}, nil
+}
+
+func (f *AtomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (f *AtomicFile) Abort() {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -2,10 +2,20 @@ package plaid_cli
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	lockTimeout       = 10 * time.Second
+	// staleLockAge is when a lock is assumed to be left behind by a
+	// process that crashed. Locks are only held for a file write.
+	staleLockAge = time.Minute
 )
 
 type Data struct {
@@ -13,6 +23,19 @@ type Data struct {
 	Tokens      map[string]string
 	Aliases     map[string]string
 	BackAliases map[string]string
+	Items       map[string]ItemEnvironment
+	// LastRuns maps item IDs to the end date of their last
+	// `transactions --since-last-run`.
+	LastRuns map[string]string
+	// Annotations maps transaction IDs to the tags and notes added with
+	// `plaid-cli tag` and `plaid-cli note`.
+	Annotations map[string]Annotation
+	// ReportedAnomalies maps the anomalies reported by
+	// `anomalies --new-only` to the date of their transaction.
+	ReportedAnomalies map[string]string
+	// SyncCursors maps item IDs to where their next transactions sync
+	// starts.
+	SyncCursors map[string]string
 }
 
 func LoadData(dataDir string) (*Data, error) {
@@ -25,6 +48,11 @@ func LoadData(dataDir string) (*Data, error) {
 
 	data.loadTokens()
 	data.loadAliases()
+	data.loadItems()
+	data.loadLastRuns()
+	data.loadAnnotations()
+	data.loadReportedAnomalies()
+	data.loadSyncCursors()
 
 	return data, nil
 }
@@ -52,6 +80,122 @@ func (d *Data) aliasesPath() string {
 	return filepath.Join(d.DataDir, "data", "aliases.json")
 }
 
+func (d *Data) itemsPath() string {
+	return filepath.Join(d.DataDir, "data", "items.json")
+}
+
+func (d *Data) loadItems() {
+	var items map[string]ItemEnvironment = make(map[string]ItemEnvironment)
+	filePath := d.itemsPath()
+	err := load(filePath, &items)
+	if err != nil {
+		log.Printf("Error loading items from %s. Assuming no items are tagged. Error: %s", filePath, err)
+	}
+
+	d.Items = items
+}
+
+func (d *Data) lastRunsPath() string {
+	return filepath.Join(d.DataDir, "data", "last_runs.json")
+}
+
+func (d *Data) loadLastRuns() {
+	var lastRuns map[string]string = make(map[string]string)
+	filePath := d.lastRunsPath()
+	err := load(filePath, &lastRuns)
+	if err != nil {
+		log.Printf("Error loading last runs from %s. Assuming no previous runs. Error: %s", filePath, err)
+	}
+
+	d.LastRuns = lastRuns
+}
+
+func (d *Data) annotationsPath() string {
+	return filepath.Join(d.DataDir, "data", "annotations.json")
+}
+
+func (d *Data) loadAnnotations() {
+	var annotations map[string]Annotation = make(map[string]Annotation)
+	filePath := d.annotationsPath()
+	err := load(filePath, &annotations)
+	if err != nil {
+		log.Printf("Error loading annotations from %s. Assuming no annotations. Error: %s", filePath, err)
+	}
+
+	d.Annotations = annotations
+}
+
+func (d *Data) reportedAnomaliesPath() string {
+	return filepath.Join(d.DataDir, "data", "reported_anomalies.json")
+}
+
+func (d *Data) loadReportedAnomalies() {
+	var reported map[string]string = make(map[string]string)
+	filePath := d.reportedAnomaliesPath()
+	err := load(filePath, &reported)
+	if err != nil {
+		log.Printf("Error loading reported anomalies from %s. Assuming none were reported. Error: %s", filePath, err)
+	}
+
+	d.ReportedAnomalies = reported
+}
+
+func (d *Data) syncCursorsPath() string {
+	return filepath.Join(d.DataDir, "data", "sync_cursors.json")
+}
+
+func (d *Data) loadSyncCursors() {
+	var cursors map[string]string = make(map[string]string)
+	filePath := d.syncCursorsPath()
+	err := load(filePath, &cursors)
+	if err != nil {
+		log.Printf("Error loading sync cursors from %s. Assuming no previous syncs. Error: %s", filePath, err)
+	}
+
+	d.SyncCursors = cursors
+}
+
+func (d *Data) syncCursorsLockPath() string {
+	return d.syncCursorsPath() + ".lock"
+}
+
+// ReloadSyncCursors reads the sync cursors again, to pick up syncs that other
+// plaid-cli processes saved since the data was loaded.
+func (d *Data) ReloadSyncCursors() error {
+	unlock, err := lockFile(d.syncCursorsLockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cursors := make(map[string]string)
+	if err := load(d.syncCursorsPath(), &cursors); err != nil {
+		return err
+	}
+	d.SyncCursors = cursors
+
+	return nil
+}
+
+// SaveSyncCursor saves where an item's next sync starts, keeping the cursors
+// of other items that other plaid-cli processes saved meanwhile.
+func (d *Data) SaveSyncCursor(itemID string, cursor string) error {
+	unlock, err := lockFile(d.syncCursorsLockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cursors := make(map[string]string)
+	if err := load(d.syncCursorsPath(), &cursors); err != nil {
+		return err
+	}
+	cursors[itemID] = cursor
+	d.SyncCursors = cursors
+
+	return save(cursors, d.syncCursorsPath())
+}
+
 func (d *Data) loadTokens() {
 	var tokens map[string]string = make(map[string]string)
 	filePath := d.tokensPath()
@@ -75,6 +219,11 @@ func load(filePath string, v interface{}) error {
 			return err
 		}
 
+		// Files are created empty on first run.
+		if len(b) == 0 {
+			return nil
+		}
+
 		return json.Unmarshal(b, v)
 	}
 }
@@ -90,9 +239,49 @@ func (d *Data) Save() error {
 		return err
 	}
 
+	err = d.SaveItems()
+	if err != nil {
+		return err
+	}
+
+	err = d.SaveLastRuns()
+	if err != nil {
+		return err
+	}
+
+	err = d.SaveAnnotations()
+	if err != nil {
+		return err
+	}
+
+	err = d.SaveReportedAnomalies()
+	if err != nil {
+		return err
+	}
+
+	err = d.SaveSyncCursors()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// RemoveItem forgets the access token and alias of an item.
+func (d *Data) RemoveItem(itemID string) error {
+	delete(d.Tokens, itemID)
+	delete(d.Items, itemID)
+	delete(d.LastRuns, itemID)
+	delete(d.SyncCursors, itemID)
+
+	if alias, ok := d.BackAliases[itemID]; ok {
+		delete(d.Aliases, alias)
+		delete(d.BackAliases, itemID)
+	}
+
+	return d.Save()
+}
+
 func (d *Data) SaveTokens() error {
 	return save(d.Tokens, d.tokensPath())
 }
@@ -101,8 +290,34 @@ func (d *Data) SaveAliases() error {
 	return save(d.Aliases, d.aliasesPath())
 }
 
+func (d *Data) SaveItems() error {
+	return save(d.Items, d.itemsPath())
+}
+
+func (d *Data) SaveLastRuns() error {
+	return save(d.LastRuns, d.lastRunsPath())
+}
+
+func (d *Data) SaveAnnotations() error {
+	return save(d.Annotations, d.annotationsPath())
+}
+
+func (d *Data) SaveReportedAnomalies() error {
+	return save(d.ReportedAnomalies, d.reportedAnomaliesPath())
+}
+
+func (d *Data) SaveSyncCursors() error {
+	unlock, err := lockFile(d.syncCursorsLockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return save(d.SyncCursors, d.syncCursorsPath())
+}
+
 func save(v interface{}, filePath string) error {
-	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0755)
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
 	}
@@ -116,3 +331,29 @@ func save(v interface{}, filePath string) error {
 	_, err = f.Write(b)
 	return err
 }
+
+// lockFile creates a lock file at path, waiting while another process holds
+// it. It works the same on every platform, unlike flock.
+func lockFile(path string) (unlock func(), err error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for %s. If no other plaid-cli is running, remove it.", path)
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+}
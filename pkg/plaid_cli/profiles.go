@@ -0,0 +1,106 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// DefaultProfile is used when --profile isn't given. Its data lives directly
+// in the data directory, as it did before profiles existed.
+const DefaultProfile = "default"
+
+var profileNameRegexp = regexp.MustCompile(`^[\w-]+$`)
+
+func IsValidProfileName(name string) bool {
+	return profileNameRegexp.MatchString(name)
+}
+
+// ProfileDir returns the directory holding a profile's tokens and aliases.
+func ProfileDir(dataDir string, profile string) string {
+	if profile == DefaultProfile {
+		return dataDir
+	}
+
+	return filepath.Join(dataDir, "profiles", profile)
+}
+
+// ProfileExists reports whether a profile has a data directory, for profiles
+// configured only through the environment.
+func ProfileExists(dataDir string, profile string) bool {
+	info, err := os.Stat(ProfileDir(dataDir, profile))
+	return err == nil && info.IsDir()
+}
+
+// KnownProfiles lists the default profile, the configured ones and the ones
+// with a data directory, sorted.
+func KnownProfiles(dataDir string, configured []string) []string {
+	seen := map[string]bool{DefaultProfile: true}
+	for _, name := range configured {
+		seen[name] = true
+	}
+	if infos, err := ioutil.ReadDir(filepath.Join(dataDir, "profiles")); err == nil {
+		for _, info := range infos {
+			if info.IsDir() {
+				seen[info.Name()] = true
+			}
+		}
+	}
+
+	var profiles []string
+	for name := range seen {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	return profiles
+}
+
+// ItemEnvironment records where an item was linked. Access tokens only work
+// in the Plaid environment and for the client ID that created them.
+type ItemEnvironment struct {
+	Environment string     `json:"environment"`
+	ClientID    string     `json:"client_id"`
+	LinkedAt    *time.Time `json:"linked_at,omitempty"`
+}
+
+// TagItem records the environment an item belongs to. Items tagged since the
+// data was loaded are kept.
+func (d *Data) TagItem(itemID string, env ItemEnvironment) error {
+	items := make(map[string]ItemEnvironment)
+	if err := load(d.itemsPath(), &items); err != nil {
+		return err
+	}
+	items[itemID] = env
+	d.Items = items
+
+	return d.SaveItems()
+}
+
+// CheckItemEnvironment returns an error if the item was linked under another
+// environment or client ID. Items linked before plaid-cli recorded this are
+// assumed to match.
+func (d *Data) CheckItemEnvironment(itemID string, env ItemEnvironment) error {
+	tagged, ok := d.Items[itemID]
+	if !ok {
+		return nil
+	}
+
+	if tagged.Environment != env.Environment {
+		return NewConfigError("Item %s was linked in the %s environment, but plaid-cli is configured for %s. Use --profile to select the profile it belongs to.", itemID, tagged.Environment, env.Environment)
+	}
+
+	if tagged.ClientID != env.ClientID {
+		return NewConfigError("Item %s was linked with client ID %s, but plaid-cli is configured with %s. Use --profile to select the profile it belongs to.", itemID, tagged.ClientID, env.ClientID)
+	}
+
+	return nil
+}
+
+func (e ItemEnvironment) String() string {
+	return fmt.Sprintf("%s (client ID %s)", e.Environment, e.ClientID)
+}
@@ -0,0 +1,430 @@
+package plaid_cli
+
+import (
+	"encoding/json"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Statuses of a recurring stream.
+const (
+	RecurringActive   = "active"
+	RecurringNew      = "new"
+	RecurringMissed   = "missed"
+	RecurringInactive = "inactive"
+)
+
+// cadence is how often a recurring stream repeats.
+type cadence struct {
+	name    string
+	minDays int
+	maxDays int
+	// period is the typical number of days between occurrences.
+	period int
+	next   func(time.Time) time.Time
+}
+
+var cadences = []cadence{
+	{"weekly", 6, 8, 7, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{"biweekly", 13, 16, 14, func(t time.Time) time.Time { return t.AddDate(0, 0, 14) }},
+	{"monthly", 26, 35, 30, func(t time.Time) time.Time { return addMonths(t, 1) }},
+	{"quarterly", 85, 97, 91, func(t time.Time) time.Time { return addMonths(t, 3) }},
+	{"annual", 350, 380, 365, func(t time.Time) time.Time { return addMonths(t, 12) }},
+}
+
+// Plaid's frequencies, mapped to cadences.
+var plaidFrequencies = map[string]string{
+	"WEEKLY":       "weekly",
+	"BIWEEKLY":     "biweekly",
+	"SEMI_MONTHLY": "semi-monthly",
+	"MONTHLY":      "monthly",
+	"ANNUALLY":     "annual",
+}
+
+func lookupCadence(name string) (cadence, bool) {
+	if name == "semi-monthly" {
+		return cadence{"semi-monthly", 13, 17, 15, func(t time.Time) time.Time { return t.AddDate(0, 0, 15) }}, true
+	}
+
+	for _, c := range cadences {
+		if c.name == name {
+			return c, true
+		}
+	}
+
+	return cadence{}, false
+}
+
+// grace is how late an occurrence can be before it's considered missed.
+func (c cadence) grace() int {
+	days := c.period / 4
+	if days < 2 {
+		days = 2
+	}
+	if days > 10 {
+		days = 10
+	}
+
+	return days
+}
+
+// PriceChange is a change in the amount of a recurring stream.
+type PriceChange struct {
+	Date string  `json:"date"`
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+}
+
+// RecurringStream is a series of transactions that repeat on a cadence.
+// Amounts follow Plaid's convention, where money leaving the account is
+// positive.
+type RecurringStream struct {
+	ItemID        string        `json:"item_id"`
+	AccountID     string        `json:"account_id"`
+	Merchant      string        `json:"merchant"`
+	Cadence       string        `json:"cadence"`
+	Count         int           `json:"count"`
+	FirstDate     string        `json:"first_date"`
+	LastDate      string        `json:"last_date"`
+	NextDate      string        `json:"next_date,omitempty"`
+	AverageAmount float64       `json:"average_amount"`
+	LastAmount    float64       `json:"last_amount"`
+	Currency      string        `json:"currency"`
+	PriceChanges  []PriceChange `json:"price_changes,omitempty"`
+	Status        string        `json:"status"`
+	// Source is "plaid" when the stream comes from Plaid's recurring
+	// transactions and "local" when plaid-cli detected it.
+	Source string `json:"source"`
+}
+
+// RecurringOptions configures DetectRecurring.
+type RecurringOptions struct {
+	// AmountTolerance is how much an amount may differ from the previous
+	// one, as a fraction, for transactions to belong to the same stream.
+	AmountTolerance float64
+	// IncludeInflows also detects recurring money coming in, like salaries.
+	IncludeInflows bool
+	// History is the range of the transactions being analyzed.
+	History DateRange
+	Now     time.Time
+}
+
+func parseDay(s string) (time.Time, error) {
+	return time.Parse(DateFormat, s)
+}
+
+// status flags streams whose next occurrence is overdue, and streams that
+// started recently.
+func (s *RecurringStream) status(c cadence, first, last time.Time, opts RecurringOptions) string {
+	today, _ := parseDay(opts.Now.Format(DateFormat))
+	historyStart, _ := parseDay(opts.History.From.Format(DateFormat))
+
+	next := c.next(last)
+	s.NextDate = next.Format(DateFormat)
+
+	switch {
+	case today.After(next.AddDate(0, 0, c.grace())):
+		return RecurringMissed
+	case first.After(historyStart.AddDate(0, 0, c.period+c.grace())) &&
+		first.After(today.AddDate(0, 0, -4*c.period)):
+		return RecurringNew
+	default:
+		return RecurringActive
+	}
+}
+
+var merchantNoise = regexp.MustCompile(`[\d#*]+`)
+
+// normalizeMerchant groups names like "NETFLIX.COM 1234" and
+// "NETFLIX.COM 5678" together.
+func normalizeMerchant(name string) string {
+	name = merchantNoise.ReplaceAllString(strings.ToLower(name), " ")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+type occurrence struct {
+	date   time.Time
+	amount float64
+	tx     Transaction
+}
+
+// DetectRecurring finds recurring streams in the transaction history of an
+// item. Transactions are grouped by merchant, and a group is recurring when
+// the time between transactions fits a cadence and amounts stay within the
+// tolerance.
+func DetectRecurring(txs []Transaction, opts RecurringOptions) []*RecurringStream {
+	groups := map[string][]occurrence{}
+	var keys []string
+
+	for _, tx := range txs {
+		if tx.Pending || (tx.Amount < 0 && !opts.IncludeInflows) {
+			continue
+		}
+
+		date, err := parseDay(tx.Date)
+		if err != nil {
+			continue
+		}
+
+		// Charges in different currencies are different streams.
+		key := normalizeMerchant(Merchant(tx)) + " " + Currency(tx)
+		if tx.Amount < 0 {
+			key = "in:" + key
+		}
+
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], occurrence{date, tx.Amount, tx})
+	}
+
+	var streams []*RecurringStream
+	for _, key := range keys {
+		if s := detectStream(groups[key], opts); s != nil {
+			streams = append(streams, s)
+		}
+	}
+
+	return streams
+}
+
+func detectStream(occurrences []occurrence, opts RecurringOptions) *RecurringStream {
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].date.Before(occurrences[j].date)
+	})
+
+	// Several charges on the same day count as one.
+	var merged []occurrence
+	for _, o := range occurrences {
+		if n := len(merged); n > 0 && merged[n-1].date.Equal(o.date) {
+			merged[n-1].amount += o.amount
+			continue
+		}
+		merged = append(merged, o)
+	}
+
+	if len(merged) < 2 {
+		return nil
+	}
+
+	intervals := make([]int, 0, len(merged)-1)
+	for i := 1; i < len(merged); i++ {
+		intervals = append(intervals, int(merged[i].date.Sub(merged[i-1].date).Hours()/24))
+	}
+
+	sorted := append([]int(nil), intervals...)
+	sort.Ints(sorted)
+	median := sorted[len(sorted)/2]
+
+	var c cadence
+	found := false
+	for _, candidate := range cadences {
+		if median >= candidate.minDays && median <= candidate.maxDays {
+			c, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	// Two occurrences are enough for an annual charge, more frequent ones
+	// need three.
+	if len(merged) < 3 && c.name != "annual" {
+		return nil
+	}
+
+	regular := 0
+	for _, days := range intervals {
+		if days >= c.minDays && days <= c.maxDays {
+			regular++
+		}
+	}
+	if float64(regular) < 0.75*float64(len(intervals)) {
+		return nil
+	}
+
+	var priceChanges []PriceChange
+	outliers := 0
+	total := 0.0
+	for i, o := range merged {
+		total += o.amount
+		if i == 0 {
+			continue
+		}
+
+		prev := merged[i-1].amount
+		if math.Abs(o.amount-prev) > opts.AmountTolerance*math.Abs(prev) {
+			outliers++
+		}
+
+		// Only a change from a steady amount is a price change. Amounts
+		// that vary every time, like utility bills, aren't.
+		steady := i == 1 || math.Abs(prev-merged[i-2].amount) < 0.005
+		if steady && math.Abs(o.amount-prev) >= 0.005 {
+			priceChanges = append(priceChanges, PriceChange{
+				Date: o.date.Format(DateFormat),
+				From: prev,
+				To:   o.amount,
+			})
+		}
+	}
+	if outliers > len(intervals)/4 {
+		return nil
+	}
+
+	first, last := merged[0], merged[len(merged)-1]
+	s := &RecurringStream{
+		AccountID:     last.tx.AccountID,
+		Merchant:      Merchant(last.tx),
+		Cadence:       c.name,
+		Count:         len(merged),
+		FirstDate:     first.date.Format(DateFormat),
+		LastDate:      last.date.Format(DateFormat),
+		AverageAmount: total / float64(len(merged)),
+		LastAmount:    last.amount,
+		Currency:      Currency(last.tx),
+		PriceChanges:  priceChanges,
+		Source:        "local",
+	}
+	s.Status = s.status(c, first.date, last.date, opts)
+
+	return s
+}
+
+type recurringRequest struct {
+	ClientID    string   `json:"client_id"`
+	Secret      string   `json:"secret"`
+	AccessToken string   `json:"access_token"`
+	AccountIDs  []string `json:"account_ids"`
+}
+
+type plaidRecurringAmount struct {
+	Amount                 float64 `json:"amount"`
+	ISOCurrencyCode        string  `json:"iso_currency_code"`
+	UnofficialCurrencyCode string  `json:"unofficial_currency_code"`
+}
+
+type plaidRecurringStream struct {
+	AccountID      string               `json:"account_id"`
+	Description    string               `json:"description"`
+	MerchantName   string               `json:"merchant_name"`
+	FirstDate      string               `json:"first_date"`
+	LastDate       string               `json:"last_date"`
+	Frequency      string               `json:"frequency"`
+	TransactionIDs []string             `json:"transaction_ids"`
+	AverageAmount  plaidRecurringAmount `json:"average_amount"`
+	LastAmount     plaidRecurringAmount `json:"last_amount"`
+	IsActive       bool                 `json:"is_active"`
+	Status         string               `json:"status"`
+}
+
+type recurringResponse struct {
+	plaid.APIResponse
+	InflowStreams  []plaidRecurringStream `json:"inflow_streams"`
+	OutflowStreams []plaidRecurringStream `json:"outflow_streams"`
+}
+
+// GetRecurring fetches the recurring streams Plaid detected for an item from
+// /transactions/recurring/get, which needs to be enabled for the Plaid
+// account.
+func GetRecurring(client *plaid.Client, credentials Credentials, token string, opts RecurringOptions) ([]*RecurringStream, error) {
+	accounts, err := client.GetAccounts(token)
+	if err != nil {
+		return nil, err
+	}
+
+	req := recurringRequest{
+		ClientID:    credentials.ClientID,
+		Secret:      credentials.Secret,
+		AccessToken: token,
+	}
+	for _, account := range accounts.Accounts {
+		req.AccountIDs = append(req.AccountIDs, account.AccountID)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res recurringResponse
+	if err := client.Call("/transactions/recurring/get", body, &res); err != nil {
+		return nil, err
+	}
+
+	plaidStreams := res.OutflowStreams
+	if opts.IncludeInflows {
+		plaidStreams = append(plaidStreams, res.InflowStreams...)
+	}
+
+	var streams []*RecurringStream
+	for _, ps := range plaidStreams {
+		merchant := ps.MerchantName
+		if merchant == "" {
+			merchant = ps.Description
+		}
+
+		s := &RecurringStream{
+			AccountID:     ps.AccountID,
+			Merchant:      merchant,
+			Cadence:       "unknown",
+			Count:         len(ps.TransactionIDs),
+			FirstDate:     ps.FirstDate,
+			LastDate:      ps.LastDate,
+			AverageAmount: ps.AverageAmount.Amount,
+			LastAmount:    ps.LastAmount.Amount,
+			Currency:      ps.AverageAmount.ISOCurrencyCode,
+			Status:        RecurringActive,
+			Source:        "plaid",
+		}
+		if s.Currency == "" {
+			s.Currency = ps.AverageAmount.UnofficialCurrencyCode
+		}
+		if name, ok := plaidFrequencies[ps.Frequency]; ok {
+			s.Cadence = name
+		}
+
+		first, firstErr := parseDay(ps.FirstDate)
+		last, lastErr := parseDay(ps.LastDate)
+		c, ok := lookupCadence(s.Cadence)
+		if ok && firstErr == nil && lastErr == nil {
+			s.Status = s.status(c, first, last, opts)
+		}
+
+		switch {
+		case !ps.IsActive:
+			s.Status = RecurringInactive
+		case ps.Status == "EARLY_DETECTION" && s.Status == RecurringActive:
+			s.Status = RecurringNew
+		}
+
+		streams = append(streams, s)
+	}
+
+	return streams, nil
+}
+
+var recurringStatusOrder = map[string]int{
+	RecurringMissed:   0,
+	RecurringNew:      1,
+	RecurringActive:   2,
+	RecurringInactive: 3,
+}
+
+// SortRecurring orders streams by status, missed and new ones first, then by
+// merchant.
+func SortRecurring(streams []*RecurringStream) {
+	sort.SliceStable(streams, func(i, j int) bool {
+		a, b := streams[i], streams[j]
+		if a.Status != b.Status {
+			return recurringStatusOrder[a.Status] < recurringStatusOrder[b.Status]
+		}
+		return strings.ToLower(a.Merchant) < strings.ToLower(b.Merchant)
+	})
+}
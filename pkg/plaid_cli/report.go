@@ -0,0 +1,443 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// ReportTopMerchants is how many merchants a report lists.
+const ReportTopMerchants = 10
+
+// ReportRow is a line of a report comparing a month with the previous one.
+type ReportRow struct {
+	Name     string
+	Amount   float64
+	Previous float64
+}
+
+// Change is the difference with the previous month, in percent. It is NaN
+// when there was nothing to compare with.
+func (r ReportRow) Change() float64 {
+	if r.Previous == 0 {
+		return math.NaN()
+	}
+
+	return (r.Amount - r.Previous) / math.Abs(r.Previous) * 100
+}
+
+// BalancePoint is the balance of an account at the end of a day.
+type BalancePoint struct {
+	Date    time.Time
+	Balance float64
+}
+
+// AccountTrend is the balance of an account over a month, in the
+// account's currency.
+type AccountTrend struct {
+	Name     string
+	Currency string
+	Points   []BalancePoint
+}
+
+// ReportAccount is an account along with the item it belongs to.
+type ReportAccount struct {
+	ItemID  string
+	Account plaid.Account
+}
+
+// Report is a monthly spending review.
+type Report struct {
+	Month    DateRange
+	Previous DateRange
+	// Currency is the currency of the transactions, which have to be in a
+	// single one.
+	Currency string
+
+	Income   ReportRow
+	Expenses ReportRow
+	// Categories are top-level categories by spending, Merchants the
+	// merchants with the most spending.
+	Categories []ReportRow
+	Merchants  []ReportRow
+	Balances   []AccountTrend
+
+	Generated time.Time
+}
+
+// BuildReport builds the report for month from transactions covering at
+// least the previous month up to now, so balances can be traced back from
+// the accounts' current balances. Transfers are left out of spending and
+// income.
+func BuildReport(txs []Transaction, accounts []ReportAccount, month DateRange, now time.Time) *Report {
+	r := &Report{
+		Month:     month,
+		Previous:  DateRange{month.From.AddDate(0, -1, 0), month.From.AddDate(0, 0, -1)},
+		Income:    ReportRow{Name: "Income"},
+		Expenses:  ReportRow{Name: "Expenses"},
+		Generated: now,
+	}
+	if currencies := Currencies(txs); len(currencies) > 0 {
+		r.Currency = currencies[0]
+	}
+
+	categories := map[string]*ReportRow{}
+	merchants := map[string]*ReportRow{}
+
+	add := func(rows map[string]*ReportRow, name string, amount float64, current bool) {
+		row, ok := rows[name]
+		if !ok {
+			row = &ReportRow{Name: name}
+			rows[name] = row
+		}
+		if current {
+			row.Amount += amount
+		} else {
+			row.Previous += amount
+		}
+	}
+
+	for _, tx := range txs {
+		if IsTransfer(tx) {
+			continue
+		}
+
+		date, err := parseDay(tx.Date)
+		if err != nil {
+			continue
+		}
+
+		var current bool
+		switch {
+		case inRange(date, r.Month):
+			current = true
+		case inRange(date, r.Previous):
+			current = false
+		default:
+			continue
+		}
+
+		if tx.Amount < 0 {
+			if current {
+				r.Income.Amount -= tx.Amount
+			} else {
+				r.Income.Previous -= tx.Amount
+			}
+			continue
+		}
+
+		if current {
+			r.Expenses.Amount += tx.Amount
+		} else {
+			r.Expenses.Previous += tx.Amount
+		}
+
+		category := "Uncategorized"
+		if len(tx.Category) > 0 {
+			category = tx.Category[0]
+		}
+		add(categories, category, tx.Amount, current)
+		add(merchants, Merchant(tx), tx.Amount, current)
+	}
+
+	r.Categories = sortedRows(categories, 0)
+	r.Merchants = sortedRows(merchants, ReportTopMerchants)
+	r.Balances = balanceTrends(txs, accounts, month, now)
+
+	return r
+}
+
+func inRange(date time.Time, r DateRange) bool {
+	from, _ := parseDay(r.From.Format(DateFormat))
+	to, _ := parseDay(r.To.Format(DateFormat))
+	return !date.Before(from) && !date.After(to)
+}
+
+// sortedRows orders rows by amount this month and keeps the first limit
+// ones. Rows that only had spending in the previous month are kept, so a
+// drop to nothing shows in the comparison; rows without spending in either
+// month, like refunds only, are dropped.
+func sortedRows(rows map[string]*ReportRow, limit int) []ReportRow {
+	var sorted []ReportRow
+	for _, row := range rows {
+		if row.Amount > 0 || row.Previous > 0 {
+			sorted = append(sorted, *row)
+		}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Amount != sorted[j].Amount {
+			return sorted[i].Amount > sorted[j].Amount
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if limit > 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+
+	return sorted
+}
+
+// balanceTrends traces each account's balance back from its current
+// balance, day by day, through its transactions. For credit and loan
+// accounts the balance is what's owed, so transactions move it the other
+// way.
+func balanceTrends(txs []Transaction, accounts []ReportAccount, month DateRange, now time.Time) []AccountTrend {
+	today, _ := parseDay(now.Format(DateFormat))
+	start, _ := parseDay(month.From.Format(DateFormat))
+	end, _ := parseDay(month.To.Format(DateFormat))
+	if end.After(today) {
+		end = today
+	}
+
+	byAccount := map[string]map[string]float64{}
+	for _, tx := range txs {
+		if tx.Pending {
+			continue
+		}
+		if byAccount[tx.AccountID] == nil {
+			byAccount[tx.AccountID] = map[string]float64{}
+		}
+		byAccount[tx.AccountID][tx.Date] += NativeAmount(tx)
+	}
+
+	var trends []AccountTrend
+	for _, a := range accounts {
+		account := a.Account
+		sign := 1.0
+		if account.Type == "credit" || account.Type == "loan" {
+			sign = -1.0
+		}
+
+		name := account.Name
+		if account.Mask != "" {
+			name = fmt.Sprintf("%s (…%s)", name, account.Mask)
+		}
+
+		currency := account.Balances.ISOCurrencyCode
+		if currency == "" {
+			currency = account.Balances.UnofficialCurrencyCode
+		}
+
+		trend := AccountTrend{Name: name, Currency: currency}
+		daily := byAccount[account.AccountID]
+
+		// Walk back from today. The balance at the end of a day is the
+		// balance at the end of the next day, undoing the next day's
+		// transactions.
+		balance := account.Balances.Current
+		var points []BalancePoint
+		for day := today; !day.Before(start); day = day.AddDate(0, 0, -1) {
+			if !day.After(end) {
+				points = append(points, BalancePoint{day, balance})
+			}
+			balance += sign * daily[day.Format(DateFormat)]
+		}
+
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+		trend.Points = points
+
+		trends = append(trends, trend)
+	}
+
+	return trends
+}
+
+const (
+	chartWidth  = 640
+	labelWidth  = 200
+	valueWidth  = 90
+	barHeight   = 18
+	barGap      = 8
+	lineHeight  = 160
+	chartMargin = 40
+)
+
+// barChart renders horizontal bars for this month, with the previous month
+// as a thinner bar underneath.
+func barChart(rows []ReportRow) template.HTML {
+	if len(rows) == 0 {
+		return template.HTML(`<p class="empty">Nothing to show.</p>`)
+	}
+
+	max := 0.0
+	for _, row := range rows {
+		max = math.Max(max, math.Max(row.Amount, row.Previous))
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	barSpace := float64(chartWidth - labelWidth - valueWidth)
+	height := len(rows)*(barHeight+barGap) + barGap
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`, chartWidth, height, chartWidth, height)
+	for i, row := range rows {
+		y := barGap + i*(barHeight+barGap)
+		current := math.Max(row.Amount, 0) / max * barSpace
+		previous := math.Max(row.Previous, 0) / max * barSpace
+
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="label">%s</text>`, labelWidth-8, y+barHeight-5, template.HTMLEscapeString(truncate(row.Name, 28)))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" class="bar"><title>%s: %.2f</title></rect>`, labelWidth, y, current, barHeight-6, template.HTMLEscapeString(row.Name), row.Amount)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="4" class="previous"><title>Previous month: %.2f</title></rect>`, labelWidth, y+barHeight-5, previous, row.Previous)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" class="value">%.2f</text>`, labelWidth+int(current)+6, y+barHeight-7, row.Amount)
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
+
+// lineChart renders an account's balance over the month.
+func lineChart(trend AccountTrend) template.HTML {
+	if len(trend.Points) == 0 {
+		return template.HTML(`<p class="empty">No balance history.</p>`)
+	}
+
+	min, max := trend.Points[0].Balance, trend.Points[0].Balance
+	for _, p := range trend.Points {
+		min = math.Min(min, p.Balance)
+		max = math.Max(max, p.Balance)
+	}
+	if max == min {
+		max, min = max+1, min-1
+	}
+
+	plotWidth := float64(chartWidth - 2*chartMargin - valueWidth)
+	plotHeight := float64(lineHeight - 2*chartMargin)
+	x := func(i int) float64 {
+		if len(trend.Points) == 1 {
+			return float64(chartMargin+valueWidth) + plotWidth/2
+		}
+		return float64(chartMargin+valueWidth) + float64(i)/float64(len(trend.Points)-1)*plotWidth
+	}
+	y := func(balance float64) float64 {
+		return float64(chartMargin) + (max-balance)/(max-min)*plotHeight
+	}
+
+	var points []string
+	for i, p := range trend.Points {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(i), y(p.Balance)))
+	}
+
+	first, last := trend.Points[0], trend.Points[len(trend.Points)-1]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" role="img">`, chartWidth, lineHeight, chartWidth, lineHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="value axis">%.2f</text>`, chartMargin, y(max)+4, max)
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" class="value axis">%.2f</text>`, chartMargin, y(min)+4, min)
+	fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" class="axis"/>`, x(0), y(min), x(len(trend.Points)-1), y(min))
+	fmt.Fprintf(&b, `<polyline points="%s" class="line"><title>%s</title></polyline>`, strings.Join(points, " "), template.HTMLEscapeString(trend.Name))
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="value">%s</text>`, x(0), lineHeight-12, first.Date.Format("Jan 2"))
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="value end">%s: %.2f</text>`, x(len(trend.Points)-1), lineHeight-12, last.Date.Format("Jan 2"), last.Balance)
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func formatChange(row ReportRow) string {
+	change := row.Change()
+	if math.IsNaN(change) {
+		return "new"
+	}
+	return fmt.Sprintf("%+.0f%%", change)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"barChart":  barChart,
+	"lineChart": lineChart,
+	"change":    formatChange,
+	"month": func(r DateRange) string {
+		return r.From.Format("January 2006")
+	},
+	"rows": func(rows ...ReportRow) []ReportRow {
+		return rows
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Spending report for {{month .Month}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 720px; margin: 2em auto; padding: 0 1em; }
+h1 { font-size: 1.6em; margin-bottom: 0; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: .3em; }
+h3 { font-size: 1em; margin-bottom: .3em; }
+.subtitle, .empty, footer { color: #777; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: right; padding: .3em .5em; border-bottom: 1px solid #eee; }
+th:first-child, td:first-child { text-align: left; }
+.up { color: #b3261e; }
+.down { color: #1e7b34; }
+svg text { font-size: 12px; fill: #444; }
+svg .label { text-anchor: end; }
+svg .end { text-anchor: end; }
+svg .bar { fill: #4a78c2; }
+svg .previous { fill: #c9c9c9; }
+svg .line { fill: none; stroke: #4a78c2; stroke-width: 2; }
+svg line.axis { stroke: #ccc; }
+.legend span { display: inline-block; width: .8em; height: .8em; margin: 0 .3em 0 1em; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>Spending report for {{month .Month}}</h1>
+<p class="subtitle">Compared with {{month .Previous}}. Transfers between accounts are left out.</p>
+<p class="legend"><span style="background:#4a78c2"></span>{{month .Month}}<span style="background:#c9c9c9"></span>{{month .Previous}}</p>
+
+<h2>Income and expenses{{with .Currency}} ({{.}}){{end}}</h2>
+{{barChart (rows .Income .Expenses)}}
+<table>
+<tr><th></th><th>{{month .Month}}</th><th>{{month .Previous}}</th><th>Change</th></tr>
+{{range rows .Income .Expenses}}<tr><td>{{.Name}}</td><td>{{printf "%.2f" .Amount}}</td><td>{{printf "%.2f" .Previous}}</td><td>{{change .}}</td></tr>
+{{end}}<tr><td>Net</td><td>{{printf "%.2f" (.Net false)}}</td><td>{{printf "%.2f" (.Net true)}}</td><td></td></tr>
+</table>
+
+<h2>Spending by category{{with .Currency}} ({{.}}){{end}}</h2>
+{{barChart .Categories}}
+<table>
+<tr><th>Category</th><th>{{month .Month}}</th><th>{{month .Previous}}</th><th>Change</th></tr>
+{{range .Categories}}<tr><td>{{.Name}}</td><td>{{printf "%.2f" .Amount}}</td><td>{{printf "%.2f" .Previous}}</td><td class="{{if gt .Amount .Previous}}up{{else}}down{{end}}">{{change .}}</td></tr>
+{{end}}</table>
+
+<h2>Top merchants{{with .Currency}} ({{.}}){{end}}</h2>
+{{barChart .Merchants}}
+
+<h2>Balances</h2>
+{{range .Balances}}<h3>{{.Name}}{{with .Currency}} ({{.}}){{end}}</h3>
+{{lineChart .}}
+{{else}}<p class="empty">No accounts.</p>
+{{end}}
+<footer><p>Generated by plaid-cli on {{.Generated.Format "2006-01-02 15:04"}}.</p></footer>
+</body>
+</html>
+`))
+
+// Net is income minus expenses, for this month or the previous one.
+func (r *Report) Net(previous bool) float64 {
+	if previous {
+		return r.Income.Previous - r.Expenses.Previous
+	}
+	return r.Income.Amount - r.Expenses.Amount
+}
+
+// WriteHTML renders the report as a self-contained HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
@@ -0,0 +1,279 @@
+package plaid_cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// RetryPolicy configures how RetryTransport retries failed Plaid calls.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for a call, including
+	// the first one.
+	MaxAttempts int
+	// BaseDelay is the initial backoff. It doubles on every attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps a single backoff.
+	MaxDelay time.Duration
+	// ProductNotReadyInterval is how often a call is retried while Plaid
+	// reports PRODUCT_NOT_READY.
+	ProductNotReadyInterval time.Duration
+	// ProductNotReadyTimeout is how long to keep polling PRODUCT_NOT_READY
+	// before giving up.
+	ProductNotReadyTimeout time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:             5,
+		BaseDelay:               500 * time.Millisecond,
+		MaxDelay:                30 * time.Second,
+		ProductNotReadyInterval: 15 * time.Second,
+		ProductNotReadyTimeout:  5 * time.Minute,
+	}
+}
+
+// Endpoints which can safely be sent more than once. Everything else is only
+// retried when Plaid rejected the request without processing it.
+var idempotentEndpoints = map[string]bool{
+	"/accounts/get":                 true,
+	"/accounts/balance/get":         true,
+	"/categories/get":               true,
+	"/institutions/get":             true,
+	"/institutions/get_by_id":       true,
+	"/institutions/search":          true,
+	"/item/get":                     true,
+	"/link/token/create":            true,
+	"/transactions/get":             true,
+	"/transactions/recurring/get":   true,
+	"/transactions/sync":            true,
+	"/webhook_verification_key/get": true,
+}
+
+// Error codes that indicate a transient problem on the institution's or
+// Plaid's side.
+var transientErrorCodes = map[string]bool{
+	"INSTITUTION_DOWN":           true,
+	"INSTITUTION_NOT_RESPONDING": true,
+	"INTERNAL_SERVER_ERROR":      true,
+	"PLANNED_MAINTENANCE":        true,
+}
+
+type retryDecision int
+
+const (
+	noRetry retryDecision = iota
+	retryBackoff
+	retryPoll
+)
+
+// RetryTransport is an http.RoundTripper that retries Plaid API calls with
+// jittered exponential backoff. It is meant to be used as the transport of
+// the http.Client passed to plaid.NewClient.
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Policy RetryPolicy
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewRetryTransport(base http.RoundTripper, policy RetryPolicy) *RetryTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &RetryTransport{
+		Base:   base,
+		Policy: policy,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NewRetryClient returns an http.Client suitable for plaid.ClientOptions.
+func NewRetryClient(policy RetryPolicy) *http.Client {
+	return &http.Client{
+		Transport: NewRetryTransport(http.DefaultTransport, policy),
+	}
+}
+
+// contextTransport sends requests with a context. plaid-go creates requests
+// without one.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// NewClientWithContext returns a Plaid client whose requests, including the
+// waits between retries, are canceled with ctx.
+func NewClientWithContext(ctx context.Context, opts plaid.ClientOptions) (*plaid.Client, error) {
+	base := http.DefaultTransport
+	httpClient := &http.Client{}
+	if opts.HTTPClient != nil {
+		*httpClient = *opts.HTTPClient
+		if opts.HTTPClient.Transport != nil {
+			base = opts.HTTPClient.Transport
+		}
+	}
+	httpClient.Transport = &contextTransport{ctx: ctx, base: base}
+	opts.HTTPClient = httpClient
+
+	return plaid.NewClient(opts)
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	endpoint := req.URL.Path
+	idempotent := idempotentEndpoints[endpoint]
+	started := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		r := req.Clone(req.Context())
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		res, err := t.Base.RoundTrip(r)
+
+		decision, reason, wait := t.decide(res, err, idempotent)
+		if decision == noRetry {
+			return res, err
+		}
+
+		switch decision {
+		case retryBackoff:
+			if attempt >= t.Policy.MaxAttempts {
+				return res, err
+			}
+			if wait == 0 {
+				wait = t.backoff(attempt)
+			}
+		case retryPoll:
+			if time.Since(started)+t.Policy.ProductNotReadyInterval > t.Policy.ProductNotReadyTimeout {
+				return res, err
+			}
+			wait = t.Policy.ProductNotReadyInterval
+		}
+
+		if res != nil {
+			res.Body.Close()
+		}
+
+		log.Printf("%s: %s. Retrying in %s...", endpoint, reason, wait.Round(time.Millisecond))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// decide inspects the outcome of an attempt. When a Plaid error is found, the
+// response body is replaced so that the caller can still decode it.
+func (t *RetryTransport) decide(res *http.Response, err error, idempotent bool) (retryDecision, string, time.Duration) {
+	if err != nil {
+		if idempotent {
+			return retryBackoff, err.Error(), 0
+		}
+		return noRetry, "", 0
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return noRetry, "", 0
+	}
+
+	b, readErr := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if readErr != nil {
+		return noRetry, "", 0
+	}
+
+	var plaidErr struct {
+		ErrorType string `json:"error_type"`
+		ErrorCode string `json:"error_code"`
+	}
+	json.Unmarshal(b, &plaidErr)
+
+	switch {
+	case plaidErr.ErrorType == "RATE_LIMIT_EXCEEDED" || res.StatusCode == http.StatusTooManyRequests:
+		// Rate limited requests are rejected before being processed, so they
+		// are safe to retry regardless of the endpoint.
+		return retryBackoff, fmt.Sprintf("rate limited (%s)", plaidErr.ErrorCode), retryAfter(res, t.Policy.MaxDelay)
+	case plaidErr.ErrorCode == "PRODUCT_NOT_READY":
+		if idempotent {
+			return retryPoll, "product not ready yet", 0
+		}
+	case transientErrorCodes[plaidErr.ErrorCode]:
+		if idempotent {
+			return retryBackoff, plaidErr.ErrorCode, retryAfter(res, t.Policy.MaxDelay)
+		}
+	case res.StatusCode >= 500:
+		if idempotent {
+			return retryBackoff, fmt.Sprintf("HTTP %d", res.StatusCode), retryAfter(res, t.Policy.MaxDelay)
+		}
+	}
+
+	return noRetry, "", 0
+}
+
+// backoff returns a "full jitter" delay for the given attempt.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	d := t.Policy.BaseDelay << uint(attempt-1)
+	if d <= 0 || d > t.Policy.MaxDelay {
+		d = t.Policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.rand.Int63n(int64(d))) + time.Millisecond
+}
+
+// retryAfter returns the delay the Retry-After header asks for, capped at max
+// so that a server can't stall a call for longer than a backoff would.
+func retryAfter(res *http.Response, max time.Duration) time.Duration {
+	header := res.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if max > 0 && d > max {
+		d = max
+	}
+
+	return d
+}
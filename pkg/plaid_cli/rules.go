@@ -0,0 +1,210 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Names of the rules file in the data directory, in order of preference.
+var rulesFileNames = []string{"rules.toml", "rules.yaml", "rules.yml"}
+
+// RuleMatch holds the conditions of a rule. Every condition that is set has
+// to match.
+type RuleMatch struct {
+	// Name and Merchant are case-insensitive regular expressions.
+	Name     string   `mapstructure:"name"`
+	Merchant string   `mapstructure:"merchant"`
+	Min      *float64 `mapstructure:"min"`
+	Max      *float64 `mapstructure:"max"`
+	Account  string   `mapstructure:"account"`
+	// Category matches Plaid's category hierarchy by prefix.
+	Category string `mapstructure:"category"`
+
+	name     *regexp.Regexp
+	merchant *regexp.Regexp
+}
+
+// Rule rewrites the transactions it matches.
+type Rule struct {
+	Name  string    `mapstructure:"name"`
+	Match RuleMatch `mapstructure:"match"`
+
+	Payee    string   `mapstructure:"payee"`
+	Category string   `mapstructure:"category"`
+	Tags     []string `mapstructure:"tags"`
+	Drop     bool     `mapstructure:"drop"`
+}
+
+// Rules are tried in order. The first one that matches a transaction is
+// applied to it.
+type Rules []*Rule
+
+// RulesPath returns the path of the rules file in dataDir, or "" if there
+// isn't one.
+func RulesPath(dataDir string) string {
+	for _, name := range rulesFileNames {
+		path := filepath.Join(dataDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// LoadRules loads the rules file in dataDir. Having no rules file is fine.
+func LoadRules(dataDir string) (Rules, error) {
+	path := RulesPath(dataDir)
+	if path == "" {
+		return nil, nil
+	}
+
+	return ReadRulesFile(path)
+}
+
+// ReadRulesFile reads rules from a TOML or YAML file.
+func ReadRulesFile(path string) (Rules, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, NewConfigError("Couldn't read rules from %s: %s", path, err)
+	}
+
+	var file struct {
+		Rules Rules `mapstructure:"rules"`
+	}
+	if err := v.Unmarshal(&file); err != nil {
+		return nil, NewConfigError("Invalid rules in %s: %s", path, err)
+	}
+
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, NewConfigError("Invalid %s in %s: %s", rule.Name, path, err)
+		}
+	}
+
+	return file.Rules, nil
+}
+
+func (r *Rule) compile() error {
+	var err error
+
+	if r.Match.Name != "" {
+		if r.Match.name, err = regexp.Compile("(?i)" + r.Match.Name); err != nil {
+			return fmt.Errorf("name: %s", err)
+		}
+	}
+
+	if r.Match.Merchant != "" {
+		if r.Match.merchant, err = regexp.Compile("(?i)" + r.Match.Merchant); err != nil {
+			return fmt.Errorf("merchant: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether every condition of the rule matches tx.
+func (r *Rule) Matches(tx Transaction) bool {
+	m := r.Match
+
+	if m.name != nil && !m.name.MatchString(tx.Name) {
+		return false
+	}
+
+	if m.merchant != nil && !m.merchant.MatchString(tx.MerchantName) {
+		return false
+	}
+
+	if m.Min != nil && tx.Amount < *m.Min {
+		return false
+	}
+
+	if m.Max != nil && tx.Amount > *m.Max {
+		return false
+	}
+
+	if m.Account != "" && tx.AccountID != m.Account {
+		return false
+	}
+
+	if m.Category != "" && !HasCategoryPrefix(tx.Category, ParseCategory(m.Category)) {
+		return false
+	}
+
+	return true
+}
+
+// Apply rewrites tx. It returns false if the transaction should be dropped.
+func (r *Rule) Apply(tx *Transaction) bool {
+	if r.Drop {
+		return false
+	}
+
+	if r.Payee != "" {
+		tx.Payee = r.Payee
+	}
+	if r.Category != "" {
+		tx.Category = ParseCategory(r.Category)
+	}
+	tx.AddTags(r.Tags...)
+
+	return true
+}
+
+// Describe summarizes what the rule does.
+func (r *Rule) Describe() string {
+	if r.Drop {
+		return "drop"
+	}
+
+	var actions []string
+	if r.Payee != "" {
+		actions = append(actions, fmt.Sprintf("payee %q", r.Payee))
+	}
+	if r.Category != "" {
+		actions = append(actions, fmt.Sprintf("category %q", r.Category))
+	}
+	if len(r.Tags) > 0 {
+		actions = append(actions, fmt.Sprintf("tags %s", strings.Join(r.Tags, ", ")))
+	}
+
+	return strings.Join(actions, ", ")
+}
+
+// Match returns the first rule matching tx, or nil.
+func (rs Rules) Match(tx Transaction) *Rule {
+	for _, r := range rs {
+		if r.Matches(tx) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// Apply applies the first matching rule to each transaction and leaves out
+// dropped ones.
+func (rs Rules) Apply(txs []Transaction) []Transaction {
+	if len(rs) == 0 {
+		return txs
+	}
+
+	kept := make([]Transaction, 0, len(txs))
+	for _, tx := range txs {
+		if r := rs.Match(tx); r != nil && !r.Apply(&tx) {
+			continue
+		}
+		kept = append(kept, tx)
+	}
+
+	return kept
+}
@@ -0,0 +1,64 @@
+package plaid_cli
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// shellCommand runs command with the system shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+
+	return exec.Command("sh", "-c", command)
+}
+
+// RunSecretCommand runs a shell command, like `pass show plaid/secret`, and
+// returns the first line of its output. The command inherits stdin and stderr
+// so password managers can prompt to be unlocked.
+func RunSecretCommand(key string, command string, timeout time.Duration) (string, error) {
+	cmd := shellCommand(command)
+
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return "", NewConfigError("`%s` (%s) failed: %s", key, command, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		// Don't wait for Wait to return: children of the shell may keep
+		// stdout open after it's killed.
+		cmd.Process.Kill()
+		return "", NewConfigError("`%s` (%s) timed out after %s", key, command, timeout)
+	}
+	if err != nil {
+		return "", NewConfigError("`%s` (%s) failed: %s", key, command, err)
+	}
+
+	line := strings.SplitN(stdout.String(), "\n", 2)[0]
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", NewConfigError("`%s` (%s) didn't print anything", key, command)
+	}
+
+	return line, nil
+}
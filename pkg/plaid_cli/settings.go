@@ -0,0 +1,141 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SettingKind is the type of a setting's value.
+type SettingKind int
+
+const (
+	KindString SettingKind = iota
+	KindBool
+	KindInt
+	KindDuration
+	KindList
+	KindFloat
+)
+
+// Setting describes a configuration key plaid-cli understands.
+type Setting struct {
+	Key         string
+	Kind        SettingKind
+	Description string
+	// Secret settings are redacted when shown.
+	Secret bool
+	// Flag is the name of the global flag bound to the setting, if any.
+	Flag string
+}
+
+// Settings lists every configuration key, in the order they're shown.
+var Settings = []Setting{
+	{Key: "plaid.client_id", Description: "Plaid client ID"},
+	{Key: "plaid.secret", Description: "Plaid secret", Secret: true},
+	{Key: "plaid.client_id_command", Description: "Command printing the Plaid client ID"},
+	{Key: "plaid.secret_command", Description: "Command printing the Plaid secret"},
+	{Key: "plaid.command_timeout", Kind: KindDuration, Description: "Timeout for credential commands"},
+	{Key: "plaid.environment", Description: "Plaid environment (development or production)"},
+	{Key: "plaid.countries", Kind: KindList, Description: "Countries to show institutions from"},
+	{Key: "plaid.language", Description: "Language used by Plaid Link"},
+	{Key: "plaid.retry.max_attempts", Kind: KindInt, Description: "Maximum attempts for a Plaid call"},
+	{Key: "plaid.retry.base_delay", Kind: KindDuration, Description: "Initial retry backoff"},
+	{Key: "plaid.retry.max_delay", Kind: KindDuration, Description: "Maximum retry backoff"},
+	{Key: "plaid.retry.product_not_ready_interval", Kind: KindDuration, Description: "Polling interval while data isn't ready"},
+	{Key: "plaid.retry.product_not_ready_timeout", Kind: KindDuration, Description: "How long to poll while data isn't ready"},
+	{Key: "plaid.retry.item_wait", Kind: KindDuration, Description: "Wait before retrying a temporarily failing institution"},
+	{Key: "link.port", Description: "Port on which to serve Plaid Link"},
+	{Key: "link.webhook", Description: "URL Plaid sends webhooks about newly linked institutions to"},
+	{Key: "cli.data_dir", Description: "Directory holding config and data"},
+	{Key: "cli.profile", Description: "Configuration profile", Flag: "profile"},
+	{Key: "cli.no_relink", Kind: KindBool, Description: "Fail instead of prompting to relink or waiting to retry", Flag: "no-relink"},
+	{Key: "cli.error_format", Description: "Format of error messages (text or json)", Flag: "error-format"},
+	{Key: "cli.hook_timeout", Kind: KindDuration, Description: "Timeout for hook and notification commands"},
+	{Key: "budget.alert_percent", Kind: KindInt, Description: "Share of a budget spent, in percent, that raises an alert"},
+	{Key: "budget.notify_command", Description: "Command run with alerting budgets as JSON on stdin"},
+	{Key: "anomalies.large_factor", Kind: KindFloat, Description: "How many times the usual amount makes a charge unusually large"},
+	{Key: "anomalies.new_merchant_amount", Kind: KindFloat, Description: "Smallest first charge from a new merchant that is flagged"},
+	{Key: "anomalies.duplicate_days", Kind: KindInt, Description: "Days within which identical charges are flagged as duplicates"},
+	{Key: "anomalies.notify_command", Description: "Command run with new anomalies as JSON on stdin"},
+	{Key: "currency.rates_file", Description: "Exchange rates file used by --convert-to"},
+	{Key: "sync.hook_command", Description: "Command run for each new, modified or removed transaction, with the event as JSON on stdin"},
+	{Key: "webhook.hook_command", Description: "Command run for each webhook received, with the webhook as JSON on stdin"},
+	{Key: "serve.token", Description: "Bearer token required by the serve API", Secret: true},
+	{Key: "transfers.window_days", Kind: KindInt, Description: "Days apart the two sides of a transfer may be posted"},
+}
+
+// LookupSetting finds a setting by key.
+func LookupSetting(key string) (Setting, bool) {
+	for _, s := range Settings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+
+	return Setting{}, false
+}
+
+// Parse converts a value given on the command line to the setting's type.
+func (s Setting) Parse(value string) (interface{}, error) {
+	switch s.Kind {
+	case KindBool:
+		return strconv.ParseBool(value)
+	case KindInt:
+		return strconv.Atoi(value)
+	case KindFloat:
+		return strconv.ParseFloat(value, 64)
+	case KindDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case KindList:
+		var list []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		return list, nil
+	default:
+		return value, nil
+	}
+}
+
+// Format renders a value for display, redacting secrets.
+func (s Setting) Format(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	var str string
+	switch v := value.(type) {
+	case []string:
+		str = strings.Join(v, ",")
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		str = strings.Join(items, ",")
+	default:
+		str = fmt.Sprint(v)
+	}
+
+	if s.Secret && str != "" {
+		return Redact(str)
+	}
+
+	return str
+}
+
+// Redact hides all but the last few characters of a secret.
+func Redact(secret string) string {
+	if len(secret) <= 8 {
+		return "********"
+	}
+
+	return "********" + secret[len(secret)-4:]
+}
@@ -0,0 +1,249 @@
+package plaid_cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GroupBy values accepted by GroupKey.
+var GroupByFields = []string{"category", "merchant", "account", "day", "week", "month"}
+
+// Fields accepted by SortTransactions.
+var TransactionSortFields = []string{"date", "amount", "name", "merchant", "category", "account"}
+
+// Fields accepted by Summary.Sort.
+var SummarySortFields = []string{"key", "count", "total", "average", "inflow", "outflow"}
+
+// ValidateGroupBy checks that groupBy is one of GroupByFields.
+func ValidateGroupBy(groupBy string) error {
+	for _, f := range GroupByFields {
+		if f == groupBy {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Invalid --group-by %q. Choose one of %s.", groupBy, strings.Join(GroupByFields, ", "))
+}
+
+// ValidateSort checks a --sort value against the fields in valid.
+func ValidateSort(field string, valid []string) error {
+	_, _, err := parseSort(field, valid)
+	return err
+}
+
+// GroupKey returns the key of the group a transaction belongs to.
+func GroupKey(tx Transaction, groupBy string) (string, error) {
+	switch groupBy {
+	case "category":
+		return CategoryName(tx.Category), nil
+	case "merchant":
+		return Merchant(tx), nil
+	case "account":
+		return tx.AccountID, nil
+	case "day":
+		return tx.Date, nil
+	case "week":
+		date, err := time.Parse(DateFormat, tx.Date)
+		if err != nil {
+			return "", fmt.Errorf("Invalid transaction date %q", tx.Date)
+		}
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), nil
+	case "month":
+		if len(tx.Date) < 7 {
+			return "", fmt.Errorf("Invalid transaction date %q", tx.Date)
+		}
+		return tx.Date[:7], nil
+	default:
+		return "", ValidateGroupBy(groupBy)
+	}
+}
+
+// CategoryName joins a Plaid category hierarchy like
+// "Food and Drink > Restaurants".
+func CategoryName(category []string) string {
+	if len(category) == 0 {
+		return "Uncategorized"
+	}
+
+	return strings.Join(category, " > ")
+}
+
+// parseSort splits a sort field like "-amount" into the field and whether
+// the order is descending.
+func parseSort(field string, valid []string) (string, bool, error) {
+	descending := strings.HasPrefix(field, "-")
+	field = strings.TrimPrefix(field, "-")
+
+	for _, f := range valid {
+		if f == field {
+			return field, descending, nil
+		}
+	}
+
+	return "", false, fmt.Errorf("Invalid --sort %q. Choose one of %s, prefixed with - for descending order.", field, strings.Join(valid, ", "))
+}
+
+// SortTransactions sorts transactions in place by field. A field prefixed
+// with - sorts in descending order.
+func SortTransactions(txs []Transaction, field string) error {
+	field, descending, err := parseSort(field, TransactionSortFields)
+	if err != nil {
+		return err
+	}
+
+	key := func(tx Transaction) string {
+		switch field {
+		case "name":
+			return strings.ToLower(tx.Name)
+		case "merchant":
+			return strings.ToLower(Merchant(tx))
+		case "category":
+			return strings.ToLower(CategoryName(tx.Category))
+		case "account":
+			return tx.AccountID
+		default:
+			return tx.Date
+		}
+	}
+
+	less := func(i, j int) bool {
+		if field == "amount" {
+			return txs[i].Amount < txs[j].Amount
+		}
+		return key(txs[i]) < key(txs[j])
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool {
+		if descending {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	return nil
+}
+
+// Group aggregates transactions in a currency. Total and Average use
+// Plaid's sign convention, where money leaving the account is positive.
+// Inflow and Outflow are both positive.
+type Group struct {
+	Key      string  `json:"key"`
+	Currency string  `json:"currency"`
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"`
+	Average  float64 `json:"average"`
+	Inflow   float64 `json:"inflow"`
+	Outflow  float64 `json:"outflow"`
+}
+
+func (g *Group) add(tx Transaction) {
+	g.Count++
+	g.Total += tx.Amount
+	if tx.Amount < 0 {
+		g.Inflow -= tx.Amount
+	} else {
+		g.Outflow += tx.Amount
+	}
+	g.Average = g.Total / float64(g.Count)
+}
+
+// Summary is the result of aggregating transactions by a key. Amounts in
+// different currencies are never added up: there is a group per key and
+// currency, and a total per currency.
+type Summary struct {
+	GroupBy string  `json:"group_by"`
+	Groups  []Group `json:"groups"`
+	Totals  []Group `json:"totals"`
+}
+
+// Summarize aggregates transactions by groupBy, one of GroupByFields.
+// Groups are ordered by key, then currency.
+func Summarize(txs []Transaction, groupBy string) (*Summary, error) {
+	summary := &Summary{
+		GroupBy: groupBy,
+		Groups:  []Group{},
+		Totals:  []Group{},
+	}
+
+	indexes := map[string]int{}
+	totals := map[string]int{}
+	for _, tx := range txs {
+		key, err := GroupKey(tx, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		currency := Currency(tx)
+
+		i, ok := indexes[key+"\x00"+currency]
+		if !ok {
+			i = len(summary.Groups)
+			indexes[key+"\x00"+currency] = i
+			summary.Groups = append(summary.Groups, Group{Key: key, Currency: currency})
+		}
+
+		t, ok := totals[currency]
+		if !ok {
+			t = len(summary.Totals)
+			totals[currency] = t
+			summary.Totals = append(summary.Totals, Group{Key: "Total", Currency: currency})
+		}
+
+		summary.Groups[i].add(tx)
+		summary.Totals[t].add(tx)
+	}
+
+	sort.SliceStable(summary.Groups, func(i, j int) bool {
+		if summary.Groups[i].Key != summary.Groups[j].Key {
+			return summary.Groups[i].Key < summary.Groups[j].Key
+		}
+		return summary.Groups[i].Currency < summary.Groups[j].Currency
+	})
+	sort.SliceStable(summary.Totals, func(i, j int) bool {
+		return summary.Totals[i].Currency < summary.Totals[j].Currency
+	})
+
+	return summary, nil
+}
+
+// Sort orders the groups by field, one of SummarySortFields. A field
+// prefixed with - sorts in descending order.
+func (s *Summary) Sort(field string) error {
+	field, descending, err := parseSort(field, SummarySortFields)
+	if err != nil {
+		return err
+	}
+
+	value := func(g Group) float64 {
+		switch field {
+		case "count":
+			return float64(g.Count)
+		case "total":
+			return g.Total
+		case "average":
+			return g.Average
+		case "inflow":
+			return g.Inflow
+		default:
+			return g.Outflow
+		}
+	}
+
+	less := func(i, j int) bool {
+		if field == "key" {
+			return s.Groups[i].Key < s.Groups[j].Key
+		}
+		return value(s.Groups[i]) < value(s.Groups[j])
+	}
+
+	sort.SliceStable(s.Groups, func(i, j int) bool {
+		if descending {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+
+	return nil
+}
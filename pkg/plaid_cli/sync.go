@@ -0,0 +1,129 @@
+package plaid_cli
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Sync event types.
+const (
+	SyncAdded    = "added"
+	SyncModified = "modified"
+	SyncRemoved  = "removed"
+)
+
+// maxSyncPageSize is the most transactions /transactions/sync returns at
+// once.
+const maxSyncPageSize = 500
+
+// maxSyncRestarts is how many times a sync starts over when transactions
+// change while paginating.
+const maxSyncRestarts = 3
+
+// SyncEvent is a change to an item's transactions. Removed transactions
+// only have an ID.
+type SyncEvent struct {
+	Type          string       `json:"type"`
+	ItemID        string       `json:"item_id"`
+	TransactionID string       `json:"transaction_id"`
+	Transaction   *Transaction `json:"transaction,omitempty"`
+}
+
+// SyncChanges is what changed since a cursor.
+type SyncChanges struct {
+	Added    []Transaction
+	Modified []Transaction
+	Removed  []string
+	// Cursor is where the next sync starts.
+	Cursor string
+}
+
+// Events lists the changes as events of an item.
+func (c *SyncChanges) Events(itemID string) []SyncEvent {
+	var events []SyncEvent
+	for i := range c.Added {
+		events = append(events, SyncEvent{Type: SyncAdded, ItemID: itemID, TransactionID: c.Added[i].ID, Transaction: &c.Added[i]})
+	}
+	for i := range c.Modified {
+		events = append(events, SyncEvent{Type: SyncModified, ItemID: itemID, TransactionID: c.Modified[i].ID, Transaction: &c.Modified[i]})
+	}
+	for _, id := range c.Removed {
+		events = append(events, SyncEvent{Type: SyncRemoved, ItemID: itemID, TransactionID: id})
+	}
+
+	return events
+}
+
+type syncRequest struct {
+	ClientID    string `json:"client_id"`
+	Secret      string `json:"secret"`
+	AccessToken string `json:"access_token"`
+	Cursor      string `json:"cursor,omitempty"`
+	Count       int    `json:"count"`
+}
+
+type syncRemoved struct {
+	TransactionID string `json:"transaction_id"`
+}
+
+type syncResponse struct {
+	plaid.APIResponse
+	Added      []plaid.Transaction `json:"added"`
+	Modified   []plaid.Transaction `json:"modified"`
+	Removed    []syncRemoved       `json:"removed"`
+	NextCursor string              `json:"next_cursor"`
+	HasMore    bool                `json:"has_more"`
+}
+
+// SyncTransactions fetches every change to an item's transactions since
+// cursor from /transactions/sync. An empty cursor returns the whole
+// history as added transactions.
+func SyncTransactions(client *plaid.Client, credentials Credentials, token string, cursor string) (*SyncChanges, error) {
+	for restarts := 0; ; restarts++ {
+		changes, err := syncPages(client, credentials, token, cursor)
+
+		// Plaid asks to start over from the first cursor when transactions
+		// change while paginating.
+		var plaidErr plaid.Error
+		if errors.As(err, &plaidErr) && plaidErr.ErrorCode == "TRANSACTIONS_SYNC_MUTATION_DURING_PAGINATION" && restarts < maxSyncRestarts {
+			continue
+		}
+
+		return changes, err
+	}
+}
+
+func syncPages(client *plaid.Client, credentials Credentials, token string, cursor string) (*SyncChanges, error) {
+	changes := &SyncChanges{Cursor: cursor}
+
+	for {
+		body, err := json.Marshal(syncRequest{
+			ClientID:    credentials.ClientID,
+			Secret:      credentials.Secret,
+			AccessToken: token,
+			Cursor:      changes.Cursor,
+			Count:       maxSyncPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var res syncResponse
+		if err := client.Call("/transactions/sync", body, &res); err != nil {
+			return nil, err
+		}
+
+		changes.Added = append(changes.Added, NewTransactions(res.Added)...)
+		changes.Modified = append(changes.Modified, NewTransactions(res.Modified)...)
+		for _, r := range res.Removed {
+			changes.Removed = append(changes.Removed, r.TransactionID)
+		}
+		changes.Cursor = res.NextCursor
+
+		if !res.HasMore {
+			return changes, nil
+		}
+	}
+}
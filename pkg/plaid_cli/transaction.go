@@ -0,0 +1,73 @@
+package plaid_cli
+
+import (
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Transaction is a Plaid transaction along with what plaid-cli adds to it.
+// It serializes like the Plaid transaction with extra fields.
+type Transaction struct {
+	plaid.Transaction
+	// Payee replaces the name Plaid reports, when set by a rule.
+	Payee string `json:"payee,omitempty"`
+	// Tags come from rules and annotations, Note and Reimbursable from
+	// annotations.
+	Tags         []string `json:"tags,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	Reimbursable bool     `json:"reimbursable,omitempty"`
+	// Transfer marks money moving between the user's own accounts, and
+	// TransferID is the other side of it when it was paired by
+	// PairTransfers.
+	Transfer   bool   `json:"transfer"`
+	TransferID string `json:"transfer_id,omitempty"`
+	// OriginalAmount and OriginalCurrency are what Plaid reported, when
+	// the amount was converted to another currency.
+	OriginalAmount   float64 `json:"original_amount,omitempty"`
+	OriginalCurrency string  `json:"original_currency,omitempty"`
+}
+
+func NewTransactions(txs []plaid.Transaction) []Transaction {
+	transactions := make([]Transaction, len(txs))
+	for i, tx := range txs {
+		transactions[i] = Transaction{Transaction: tx}
+	}
+
+	return transactions
+}
+
+// AddTags adds tags the transaction doesn't have yet.
+func (tx *Transaction) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if !tx.HasTag(tag) {
+			tx.Tags = append(tx.Tags, tag)
+		}
+	}
+}
+
+func (tx *Transaction) HasTag(tag string) bool {
+	for _, t := range tx.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (tx *Transaction) RemoveTags(tags ...string) {
+	var kept []string
+	for _, t := range tx.Tags {
+		remove := false
+		for _, tag := range tags {
+			if t == tag {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+
+	tx.Tags = kept
+}
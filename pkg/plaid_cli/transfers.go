@@ -0,0 +1,85 @@
+package plaid_cli
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultTransferWindow is how many days apart the two sides of a transfer
+// may be posted.
+const DefaultTransferWindow = 3
+
+// PairTransfers finds money moving between the user's own accounts: an
+// outflow from one account and an inflow of the same amount and currency to
+// another, posted within window days of each other. Both sides are marked as
+// transfers and point at each other.
+//
+// others are transactions from other institutions, like the ones txs came
+// from. They're only used to find the other side of transfers, but are
+// marked too. Pending transactions are left alone, since they're replaced
+// once they post.
+func PairTransfers(txs []Transaction, others []Transaction, window int) {
+	var outflows []*Transaction
+	inflows := map[int64][]*Transaction{}
+
+	for _, list := range [][]Transaction{txs, others} {
+		for i := range list {
+			tx := &list[i]
+			if tx.Pending || tx.TransferID != "" {
+				continue
+			}
+			if _, err := parseDay(tx.Date); err != nil {
+				continue
+			}
+
+			cents := int64(math.Round(math.Abs(tx.Amount) * 100))
+			switch {
+			case tx.Amount > 0:
+				outflows = append(outflows, tx)
+			case tx.Amount < 0:
+				inflows[cents] = append(inflows[cents], tx)
+			}
+		}
+	}
+
+	// Pair the earliest outflows first, each with the closest inflow.
+	sort.SliceStable(outflows, func(i, j int) bool {
+		return outflows[i].Date < outflows[j].Date
+	})
+
+	for _, out := range outflows {
+		date, _ := parseDay(out.Date)
+		cents := int64(math.Round(out.Amount * 100))
+
+		var best *Transaction
+		bestDays := window + 1
+		for _, in := range inflows[cents] {
+			if in.TransferID != "" || in.AccountID == out.AccountID || Currency(*in) != Currency(*out) {
+				continue
+			}
+
+			inDate, _ := parseDay(in.Date)
+			days := int(math.Abs(inDate.Sub(date).Hours()) / 24)
+			if days < bestDays {
+				best, bestDays = in, days
+			}
+		}
+
+		if best != nil {
+			out.TransferID, best.TransferID = best.ID, out.ID
+			out.Transfer, best.Transfer = true, true
+		}
+	}
+}
+
+// MarkTransfers marks the transactions categorized as transfers by Plaid or
+// a rule.
+func MarkTransfers(txs []Transaction) []Transaction {
+	for i := range txs {
+		if IsTransfer(txs[i]) {
+			txs[i].Transfer = true
+		}
+	}
+
+	return txs
+}
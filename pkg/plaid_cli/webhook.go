@@ -0,0 +1,223 @@
+package plaid_cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plaid/plaid-go/plaid"
+)
+
+// Webhook types and codes plaid-cli acts on.
+const (
+	WebhookTransactions        = "TRANSACTIONS"
+	WebhookItem                = "ITEM"
+	WebhookSyncUpdates         = "SYNC_UPDATES_AVAILABLE"
+	WebhookError               = "ERROR"
+	WebhookPendingExpiration   = "PENDING_EXPIRATION"
+	WebhookNewAccounts         = "NEW_ACCOUNTS_AVAILABLE"
+	maxWebhookSize             = 1 << 20
+	DefaultWebhookMaxAge       = 5 * time.Minute
+	webhookVerificationHeader  = "Plaid-Verification"
+	webhookVerificationAlg     = "ES256"
+	webhookVerificationKeyType = "EC"
+)
+
+// Webhook is a notification Plaid sent about an item. Body is the raw
+// JSON, which has more fields depending on the webhook.
+type Webhook struct {
+	WebhookType           string       `json:"webhook_type"`
+	WebhookCode           string       `json:"webhook_code"`
+	ItemID                string       `json:"item_id"`
+	Error                 *plaid.Error `json:"error"`
+	ConsentExpirationTime string       `json:"consent_expiration_time"`
+
+	Body []byte `json:"-"`
+}
+
+func (w Webhook) String() string {
+	return w.WebhookType + " " + w.WebhookCode
+}
+
+// WebhookVerifier checks the signed JWT Plaid sends with every webhook in
+// the Plaid-Verification header.
+type WebhookVerifier struct {
+	Client *plaid.Client
+	// MaxAge is how long after it was signed a webhook is accepted.
+	MaxAge time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PublicKey
+}
+
+func NewWebhookVerifier(client *plaid.Client) *WebhookVerifier {
+	return &WebhookVerifier{
+		Client: client,
+		MaxAge: DefaultWebhookMaxAge,
+		keys:   map[string]*ecdsa.PublicKey{},
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type webhookClaims struct {
+	IssuedAt          int64  `json:"iat"`
+	RequestBodySHA256 string `json:"request_body_sha256"`
+}
+
+// Verify checks that token was signed by Plaid for body, recently.
+func (v *WebhookVerifier) Verify(token string, body []byte) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != webhookVerificationAlg {
+		return fmt.Errorf("unexpected JWT algorithm %q", header.Alg)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return errors.New("malformed JWT signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, hash[:], r, s) {
+		return errors.New("invalid JWT signature")
+	}
+
+	var claims webhookClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+	if age := time.Since(time.Unix(claims.IssuedAt, 0)); age > v.MaxAge {
+		return fmt.Errorf("webhook was signed %s ago", age.Round(time.Second))
+	}
+
+	bodyHash := sha256.Sum256(body)
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(bodyHash[:])), []byte(claims.RequestBodySHA256)) != 1 {
+		return errors.New("body doesn't match the signed hash")
+	}
+
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed JWT")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed JWT")
+	}
+
+	return nil
+}
+
+// key returns Plaid's verification key with the given ID, fetching it the
+// first time it's used.
+func (v *WebhookVerifier) key(kid string) (*ecdsa.PublicKey, error) {
+	if kid == "" {
+		return nil, errors.New("JWT has no key ID")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	res, err := v.Client.GetWebhookVerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := res.Key
+	if jwk.Kty != webhookVerificationKeyType || jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported verification key %s/%s", jwk.Kty, jwk.Crv)
+	}
+	if jwk.ExpiredAt != 0 && time.Unix(jwk.ExpiredAt, 0).Before(time.Now()) {
+		return nil, fmt.Errorf("verification key %s has expired", kid)
+	}
+
+	x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if errX != nil || errY != nil {
+		return nil, fmt.Errorf("malformed verification key %s", kid)
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	v.keys[kid] = key
+
+	return key, nil
+}
+
+// WebhookHandler receives Plaid webhooks, verifies them and passes them to
+// handle. handle must return quickly: Plaid expects an answer within a few
+// seconds. If it returns an error, Plaid is asked to try again later.
+func WebhookHandler(verifier *WebhookVerifier, handle func(Webhook) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+		if err != nil {
+			http.Error(w, "Couldn't read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := verifier.Verify(r.Header.Get(webhookVerificationHeader), body); err != nil {
+			log.Printf("Rejected a webhook from %s: %s", r.RemoteAddr, err)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var webhook Webhook
+		if err := json.Unmarshal(body, &webhook); err != nil {
+			http.Error(w, "Invalid body", http.StatusBadRequest)
+			return
+		}
+		webhook.Body = body
+
+		if err := handle(webhook); err != nil {
+			log.Printf("Couldn't handle %s webhook for %s: %s", webhook, webhook.ItemID, err)
+			http.Error(w, "Try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}